@@ -0,0 +1,297 @@
+package cligpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/eitamonya/cligpt/sse"
+	"github.com/eitamonya/cligpt/types"
+)
+
+const (
+	ANTHROPIC_BASE_URL string = "https://api.anthropic.com/v1"
+	ANTHROPIC_VERSION  string = "2023-06-01"
+	MESSAGES_PATH      string = "/messages"
+	anthropicMaxTokens int    = 4096
+)
+
+type AnthropicMessage struct {
+	Role string `json:"role"`
+	// Content is a string, or a list of blocks for messages with images
+	Content interface{} `json:"content"`
+}
+
+type AnthropicContentBlock struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *AnthropicImageSource `json:"source,omitempty"`
+}
+
+type AnthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type AnthropicRequestBody struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []AnthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature"`
+	Stream      bool               `json:"stream"`
+}
+
+type AnthropicResponseBody struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string          `json:"stop_reason"`
+	Usage      *AnthropicUsage `json:"usage"`
+}
+
+// AnthropicUsage is reported in full by message_start, message_delta only
+// carries the output tokens so far.
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+// promptTokens counts the cached input as well, it is billed too.
+func (u *AnthropicUsage) promptTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// AnthropicEvent covers the fields used from the server-sent events of a
+// streamed response.
+type AnthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage *AnthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *AnthropicUsage `json:"usage"`
+	Error *apiErrorBody   `json:"error"`
+}
+
+// anthropicProvider talks to the Anthropic Messages API.
+type anthropicProvider struct {
+	client  *apiClient
+	token   string
+	baseURL string
+	headers map[string]string
+}
+
+func newAnthropicProvider(config Config) *anthropicProvider {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = ANTHROPIC_BASE_URL
+	}
+
+	return &anthropicProvider{
+		client:  newAPIClient(config.Retry),
+		token:   config.Token,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		headers: config.Headers,
+	}
+}
+
+func (p *anthropicProvider) newRequest(ctx context.Context, method string, path string, body interface{}) (*http.Request, error) {
+	req, err := newJSONRequest(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-api-key", p.token)
+	req.Header.Set("anthropic-version", ANTHROPIC_VERSION)
+	for key, value := range p.headers {
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
+
+// buildAnthropicRequest moves the system messages, e.g. the active personality,
+// to the top level system field since the Messages API only accepts user and
+// assistant turns.
+func buildAnthropicRequest(ctx context.Context, p *anthropicProvider, request CompletionRequest, stream bool) (*http.Request, error) {
+	var reqBody AnthropicRequestBody
+
+	reqBody.Model = request.Model
+	reqBody.Stream = stream
+	reqBody.Temperature = request.Temperature
+	reqBody.MaxTokens = request.MaxTokens
+	if reqBody.MaxTokens == 0 {
+		reqBody.MaxTokens = anthropicMaxTokens
+	}
+
+	var system []string
+	for _, message := range request.Messages {
+		if message.Role == "system" {
+			system = append(system, message.Content)
+			continue
+		}
+		reqBody.Messages = append(reqBody.Messages, toAnthropicMessage(message))
+	}
+	reqBody.System = strings.Join(system, "\n\n")
+
+	return p.newRequest(ctx, "POST", MESSAGES_PATH, reqBody)
+}
+
+func toAnthropicMessage(message types.Message) AnthropicMessage {
+	if len(message.Parts) == 0 {
+		return AnthropicMessage{Role: message.Role, Content: message.Content}
+	}
+
+	var blocks []AnthropicContentBlock
+	for _, part := range message.Parts {
+		switch {
+		case part.Type == "text":
+			blocks = append(blocks, AnthropicContentBlock{Type: "text", Text: part.Text})
+		case part.Type == "image_url" && part.ImageURL != nil:
+			blocks = append(blocks, AnthropicContentBlock{Type: "image", Source: toAnthropicImageSource(part.ImageURL.URL)})
+		}
+	}
+
+	return AnthropicMessage{Role: message.Role, Content: blocks}
+}
+
+// toAnthropicImageSource splits a data:<media type>;base64,<data> URI into the
+// fields Anthropic expects, other URLs are passed on as is.
+func toAnthropicImageSource(url string) *AnthropicImageSource {
+	if header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ","); ok && strings.HasPrefix(url, "data:") {
+		return &AnthropicImageSource{
+			Type:      "base64",
+			MediaType: strings.TrimSuffix(header, ";base64"),
+			Data:      data,
+		}
+	}
+
+	return &AnthropicImageSource{Type: "url", URL: url}
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, request CompletionRequest) (CompletionResponse, error) {
+	req, err := buildAnthropicRequest(ctx, p, request, false)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+
+	resp, err := p.client.do(req)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return CompletionResponse{}, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return CompletionResponse{}, fmt.Errorf("%w: error reading response body: %v", ErrNetwork, err)
+	}
+
+	var responseBody AnthropicResponseBody
+	if err := json.Unmarshal(body, &responseBody); err != nil {
+		return CompletionResponse{}, fmt.Errorf("%w: error parsing response body: %v", ErrAPI, err)
+	}
+
+	var content string
+	for _, block := range responseBody.Content {
+		if block.Type == "text" {
+			content += block.Text
+		}
+	}
+
+	response := CompletionResponse{Content: content, Raw: body}
+	if u := responseBody.Usage; u != nil {
+		response.Usage = Usage{PromptTokens: u.promptTokens(), CompletionTokens: u.OutputTokens}
+	}
+
+	return response, nil
+}
+
+func parseAnthropicEvents(ctx context.Context, resp *http.Response, onDelta func(string)) (CompletionResponse, error) {
+	var response CompletionResponse
+
+	if err := checkResponse(resp); err != nil {
+		return response, err
+	}
+
+	reader := sse.NewReader(resp.Body)
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			return response, fmt.Errorf("%w: stream ended early", ErrNetwork)
+		}
+		if err != nil {
+			return response, streamError(ctx, err)
+		}
+
+		// The event name is repeated in the type field of the data
+		var event AnthropicEvent
+		if err := json.Unmarshal([]byte(message.Data), &event); err != nil {
+			return response, fmt.Errorf("%w: error parsing response body: %v", ErrAPI, err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if u := event.Message.Usage; u != nil {
+				response.Usage = Usage{PromptTokens: u.promptTokens(), CompletionTokens: u.OutputTokens}
+			}
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				onDelta(event.Delta.Text)
+				response.Content += event.Delta.Text
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				response.Usage.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil {
+				return response, newAPIError(event.Error)
+			}
+			return response, fmt.Errorf("%w: stream failed", ErrAPI)
+		case "message_stop":
+			return response, nil
+		}
+	}
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, request CompletionRequest, onDelta func(string)) (CompletionResponse, error) {
+	req, err := buildAnthropicRequest(ctx, p, request, true)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+
+	resp, err := p.client.do(req)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	return parseAnthropicEvents(ctx, resp, onDelta)
+}
+
+func (p *anthropicProvider) ListModels(ctx context.Context) ([]string, error) {
+	req, err := p.newRequest(ctx, "GET", MODELS_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return listModels(p.client, req)
+}
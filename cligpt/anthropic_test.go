@@ -0,0 +1,359 @@
+package cligpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+func newTestAnthropicProvider(url string) *anthropicProvider {
+	return newAnthropicProvider(Config{
+		Provider: "anthropic",
+		BaseURL:  url,
+		Token:    "sk-ant-test",
+		Headers:  map[string]string{"X-Test": "yes"},
+		Retry:    Retry{MaxRetries: -1},
+	})
+}
+
+func TestBuildAnthropicRequest(t *testing.T) {
+	p := newTestAnthropicProvider("https://example.com/v1/")
+	request := CompletionRequest{
+		Model:       "claude-sonnet-4-5",
+		Temperature: 0.5,
+		Messages: []types.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+			{Role: "system", Content: "answer in French"},
+			{Role: "user", Content: "what is this?", Parts: []types.ContentPart{
+				{Type: "text", Text: "what is this?"},
+				{Type: "image_url", ImageURL: &types.ImageURL{URL: "data:image/png;base64,iVBORw0KGgo="}},
+				{Type: "image_url", ImageURL: &types.ImageURL{URL: "https://example.com/cat.jpg"}},
+			}},
+		},
+	}
+
+	req, err := buildAnthropicRequest(context.Background(), p, request, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.URL.String() != "https://example.com/v1/messages" {
+		t.Errorf("URL = %s", req.URL)
+	}
+	for key, want := range map[string]string{
+		"x-api-key":         "sk-ant-test",
+		"anthropic-version": ANTHROPIC_VERSION,
+		"Content-Type":      "application/json",
+		"X-Test":            "yes",
+	} {
+		if got := req.Header.Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+
+	var body struct {
+		Model     string  `json:"model"`
+		System    string  `json:"system"`
+		MaxTokens int     `json:"max_tokens"`
+		Stream    bool    `json:"stream"`
+		Temp      float64 `json:"temperature"`
+		Messages  []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Model != "claude-sonnet-4-5" || !body.Stream || body.Temp != 0.5 {
+		t.Errorf("model, stream, temperature = %s, %v, %v", body.Model, body.Stream, body.Temp)
+	}
+	if body.MaxTokens != anthropicMaxTokens {
+		t.Errorf("max_tokens = %d, want the default %d", body.MaxTokens, anthropicMaxTokens)
+	}
+	if body.System != "be brief\n\nanswer in French" {
+		t.Errorf("system = %q", body.System)
+	}
+
+	var roles []string
+	for _, message := range body.Messages {
+		roles = append(roles, message.Role)
+	}
+	if strings.Join(roles, ",") != "user,assistant,user" {
+		t.Fatalf("roles = %v, the system messages should be left out", roles)
+	}
+	if string(body.Messages[0].Content) != `"hi"` {
+		t.Errorf("text content = %s", body.Messages[0].Content)
+	}
+
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(body.Messages[2].Content, &blocks); err != nil {
+		t.Fatal(err)
+	}
+	want := []AnthropicContentBlock{
+		{Type: "text", Text: "what is this?"},
+		{Type: "image", Source: &AnthropicImageSource{Type: "base64", MediaType: "image/png", Data: "iVBORw0KGgo="}},
+		{Type: "image", Source: &AnthropicImageSource{Type: "url", URL: "https://example.com/cat.jpg"}},
+	}
+	if len(blocks) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(blocks), len(want))
+	}
+	for i := range want {
+		if blocks[i].Type != want[i].Type || blocks[i].Text != want[i].Text {
+			t.Errorf("block %d = %+v, want %+v", i, blocks[i], want[i])
+		}
+		if (blocks[i].Source == nil) != (want[i].Source == nil) ||
+			(want[i].Source != nil && *blocks[i].Source != *want[i].Source) {
+			t.Errorf("block %d source = %+v, want %+v", i, blocks[i].Source, want[i].Source)
+		}
+	}
+}
+
+func TestBuildAnthropicRequestMaxTokens(t *testing.T) {
+	p := newTestAnthropicProvider("")
+	req, err := buildAnthropicRequest(context.Background(), p, CompletionRequest{MaxTokens: 100}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body AnthropicRequestBody
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.MaxTokens != 100 || body.Stream {
+		t.Errorf("max_tokens, stream = %d, %v", body.MaxTokens, body.Stream)
+	}
+	if req.URL.String() != ANTHROPIC_BASE_URL+MESSAGES_PATH {
+		t.Errorf("URL = %s", req.URL)
+	}
+}
+
+// anthropicEvent formats an event as the API sends it.
+func anthropicEvent(name string, data string) string {
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", name, data)
+}
+
+func TestParseAnthropicEvents(t *testing.T) {
+	start := anthropicEvent("message_start", `{"type":"message_start","message":{"usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":1}}}`)
+	blockStart := anthropicEvent("content_block_start", `{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`)
+	ping := anthropicEvent("ping", `{"type":"ping"}`)
+	hello := anthropicEvent("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`)
+	world := anthropicEvent("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", world"}}`)
+	blockStop := anthropicEvent("content_block_stop", `{"type":"content_block_stop","index":0}`)
+	delta := anthropicEvent("message_delta", `{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":7}}`)
+	stop := anthropicEvent("message_stop", `{"type":"message_stop"}`)
+
+	tests := []struct {
+		name    string
+		status  int
+		stream  string
+		content string
+		usage   Usage
+		err     error
+	}{
+		{
+			name:    "complete",
+			stream:  start + blockStart + ping + hello + world + blockStop + delta + stop,
+			content: "Hello, world",
+			usage:   Usage{PromptTokens: 15, CompletionTokens: 7},
+		},
+		{
+			name:    "events after message_stop are ignored",
+			stream:  start + hello + delta + stop + world,
+			content: "Hello",
+			usage:   Usage{PromptTokens: 15, CompletionTokens: 7},
+		},
+		{
+			name:    "CRLF line endings",
+			stream:  strings.ReplaceAll(start+hello+stop, "\n", "\r\n"),
+			content: "Hello",
+			usage:   Usage{PromptTokens: 15, CompletionTokens: 1},
+		},
+		{
+			name:    "stream without message_stop",
+			stream:  start + hello + world + delta,
+			content: "Hello, world",
+			usage:   Usage{PromptTokens: 15, CompletionTokens: 7},
+			err:     ErrNetwork,
+		},
+		{
+			name:    "stream cut off in an event",
+			stream:  start + hello + "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,",
+			content: "Hello",
+			usage:   Usage{PromptTokens: 15, CompletionTokens: 1},
+			err:     ErrNetwork,
+		},
+		{
+			name:    "overloaded error event",
+			stream:  start + hello + anthropicEvent("error", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`),
+			content: "Hello",
+			usage:   Usage{PromptTokens: 15, CompletionTokens: 1},
+			err:     ErrAPI,
+		},
+		{
+			name:   "rate limit error event",
+			stream: anthropicEvent("error", `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`),
+			err:    ErrRateLimited,
+		},
+		{
+			name:   "error status",
+			status: http.StatusUnauthorized,
+			stream: `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			err:    ErrAuth,
+		},
+		{
+			name:   "invalid JSON",
+			stream: start + anthropicEvent("content_block_delta", `{"type":`),
+			usage:  Usage{PromptTokens: 15, CompletionTokens: 1},
+			err:    ErrAPI,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := test.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			resp := &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(test.stream))}
+
+			var deltas []string
+			response, err := parseAnthropicEvents(context.Background(), resp, func(delta string) {
+				deltas = append(deltas, delta)
+			})
+
+			if test.err == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if response.Content != test.content {
+				t.Errorf("content = %q, want %q", response.Content, test.content)
+			}
+			if strings.Join(deltas, "") != test.content {
+				t.Errorf("deltas = %q, want %q", deltas, test.content)
+			}
+			if response.Usage != test.usage {
+				t.Errorf("usage = %+v, want %+v", response.Usage, test.usage)
+			}
+		})
+	}
+}
+
+func TestAnthropicComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "sk-ant-test" {
+			http.Error(w, `{"type":"error","error":{"type":"not_found_error","message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+
+		var body AnthropicRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Stream || body.System != "be brief" {
+			http.Error(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad request"}}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"Hello"},{"type":"tool_use"},{"type":"text","text":" there"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"cache_creation_input_tokens":3,"output_tokens":4}}`)
+	}))
+	defer server.Close()
+
+	p := newTestAnthropicProvider(server.URL + "/v1")
+	response, err := p.Complete(context.Background(), CompletionRequest{
+		Model:    "claude-sonnet-4-5",
+		Messages: []types.Message{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Content != "Hello there" {
+		t.Errorf("content = %q", response.Content)
+	}
+	if response.Usage != (Usage{PromptTokens: 15, CompletionTokens: 4}) {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+	if !strings.Contains(string(response.Raw), `"stop_reason":"end_turn"`) {
+		t.Errorf("raw = %s", response.Raw)
+	}
+}
+
+func TestAnthropicCompleteError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("request-id", "req_123")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens is too large"}}`)
+	}))
+	defer server.Close()
+
+	_, err := newTestAnthropicProvider(server.URL).Complete(context.Background(), CompletionRequest{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want an *APIError", err)
+	}
+	if apiErr.Status != http.StatusBadRequest || apiErr.Message != "max_tokens is too large" {
+		t.Errorf("error = %+v", apiErr)
+	}
+	if !errors.Is(err, ErrAPI) {
+		t.Errorf("error = %v, want ErrAPI", err)
+	}
+}
+
+func TestAnthropicStreamCancel(t *testing.T) {
+	stopped := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, anthropicEvent("message_start", `{"type":"message_start","message":{"usage":{"input_tokens":10,"output_tokens":1}}}`))
+		fmt.Fprint(w, anthropicEvent("content_block_delta", `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`))
+		w.(http.Flusher).Flush()
+
+		// The rest of the answer never comes, the client has to stop waiting
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		close(stopped)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	response, err := newTestAnthropicProvider(server.URL).Stream(ctx, CompletionRequest{}, func(string) {
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if response.Content != "Hello" {
+		t.Errorf("content = %q, the answer so far should be kept", response.Content)
+	}
+	if response.Usage.PromptTokens != 10 {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("the request wasn't closed after cancelling")
+	}
+}
@@ -2,155 +2,118 @@ package cligpt
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"io/ioutil"
-	"log"
+	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/eitamonya/cligpt/types"
 )
 
-const (
-	CHAT_URL  string = "https://api.openai.com/v1/chat/completions"
-	IMAGE_URL string = "https://api.openai.com/v1/images/generations"
-)
-
-type ChatResponseBody struct {
-	Choices []struct {
-		Message types.Message `json:"message"`
-	}
+// Provider is a chat completion backend. Every call that talks to a model goes
+// through the provider selected in the config, so adding a backend only means
+// adding a new implementation.
+type Provider interface {
+	// Complete sends the request and waits for the whole answer.
+	Complete(ctx context.Context, req CompletionRequest) (CompletionResponse, error)
+	// Stream sends the request and calls onDelta for every piece of content as
+	// it arrives, returning the full answer once the stream ends. When ctx is
+	// cancelled it returns the content received so far with ctx.Err().
+	Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (CompletionResponse, error)
+	// ListModels returns the IDs of the models available to the user.
+	ListModels(ctx context.Context) ([]string, error)
 }
 
-type ChatRequestBody struct {
-	Model       string          `json:"model"`
-	Messages    []types.Message `json:"messages"`
-	Stream      bool            `json:"stream"`
-	Temperature float64         `json:"temperature"`
-	MaxTokens   int             `json:"max_tokens,omitempty"`
+// ImageGenerator is implemented by providers that can generate images.
+type ImageGenerator interface {
+	GenerateImage(ctx context.Context, prompt string, image Image) ([]byte, error)
 }
 
-type ImageResponseBody struct {
-	Data []struct {
-		Url string `json:"url"`
-	} `json:"data"`
+type CompletionRequest struct {
+	Model       string
+	Messages    []types.Message
+	Temperature float64
+	MaxTokens   int
 }
 
-type ImageRequestBody struct {
-	Prompt  string `json:"prompt"`
-	N       int    `json:"n"`
-	Size    string `json:"size"`
-	Model   string `json:"model"`
-	Quality string `json:"quality"`
-	Style   string `json:"style"`
+type CompletionResponse struct {
+	Content string
+	// Raw is the unmodified response body, used for the --json output
+	Raw []byte
+	// Usage is zero when the API didn't report it, e.g. for a cancelled
+	// stream
+	Usage Usage
 }
 
-func buildCompletionRequest(app *appEnv) *http.Request {
-	var reqBody ChatRequestBody
-
-	reqBody.Model = app.model
-	reqBody.Stream = !app.isSinglePrompt
-	reqBody.Temperature = app.temperature
-	reqBody.MaxTokens = app.max_tokens
-	reqBody.Messages = app.currentSession.Messages
-
-	finalReqBody, err := json.Marshal(reqBody)
-	if err != nil {
-		log.Fatal("Error creating request body:", err)
-	}
+// Usage is the number of tokens a request was billed for.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
 
-	req, err := http.NewRequest("POST", CHAT_URL, bytes.NewBuffer(finalReqBody))
-	if err != nil {
-		log.Fatal("Error creating request:", err)
+func newProvider(config Config) (Provider, error) {
+	switch config.Provider {
+	case "", "openai":
+		return newOpenAIProvider(config), nil
+	case "anthropic":
+		return newAnthropicProvider(config), nil
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+app.token)
-
-	return req
+	return nil, fmt.Errorf("%w: unknown provider %q", ErrConfig, config.Provider)
 }
 
-func parseCompletionResponse(resp *http.Response) ChatResponseBody {
-	if strings.HasPrefix(http.StatusText(resp.StatusCode), "4") || strings.HasPrefix(http.StatusText(resp.StatusCode), "5") {
-		log.Fatal(stringifyResponseBody(resp))
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
+// completionRequest builds the request for the current session, with the
+// messages cut down to fit in the context window.
+func (app *appEnv) completionRequest(ctx context.Context) (CompletionRequest, error) {
+	messages, err := app.fitContext(ctx)
 	if err != nil {
-		log.Fatal("Error reading response body:", err)
+		return CompletionRequest{}, err
 	}
 
-	var responseBody ChatResponseBody
-
-	if err := json.Unmarshal(body, &responseBody); err != nil {
-		log.Fatal("Error parsing response body:", err)
-	}
-
-	return responseBody
+	return CompletionRequest{
+		Model:       app.model,
+		Messages:    messages,
+		Temperature: app.temperature,
+		MaxTokens:   app.max_tokens,
+	}, nil
 }
 
-func buildImageRequest(app *appEnv) *http.Request {
-	var reqBody ImageRequestBody
-
-	reqBody.Prompt = app.InitialPrompt
-	reqBody.N = 1
-
-	if app.image == (Image{}) {
-		reqBody.Size = "1024x1024"
-		reqBody.Model = "dall-e-3"
-		reqBody.Quality = "standard"
-		reqBody.Style = "vivid"
-	} else {
-		reqBody.Size = app.image.Size
-		reqBody.Model = app.image.Model
-		reqBody.Quality = app.image.Quality
-		reqBody.Style = app.image.Style
-	}
+func newJSONRequest(ctx context.Context, method string, url string, body interface{}) (*http.Request, error) {
+	var reqBody *bytes.Buffer = &bytes.Buffer{}
 
-	finalReqBody, err := json.Marshal(reqBody)
-	if err != nil {
-		log.Fatal("Error creating request body:", err)
+	if body != nil {
+		finalReqBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(finalReqBody)
 	}
 
-	req, err := http.NewRequest("POST", IMAGE_URL, bytes.NewBuffer(finalReqBody))
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		log.Fatal("Error creating request:", err)
+		return nil, fmt.Errorf("error creating request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+app.token)
 
-	return req
+	return req, nil
 }
 
-func parseImageResponse(resp *http.Response) ImageResponseBody {
-	if strings.HasPrefix(http.StatusText(resp.StatusCode), "4") || strings.HasPrefix(http.StatusText(resp.StatusCode), "5") {
-		log.Fatal(stringifyResponseBody(resp))
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal("Error reading response body:", err)
+// streamError wraps an error hit while reading a stream, unless the stream
+// was stopped by cancelling ctx.
+func streamError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
-	var responseBody ImageResponseBody
-
-	if err := json.Unmarshal(body, &responseBody); err != nil {
-		log.Fatal("Error parsing response body:", err)
-	}
-
-	return responseBody
+	return fmt.Errorf("%w: stream interrupted: %v", ErrNetwork, err)
 }
 
-func stringifyResponseBody(resp *http.Response) string {
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal("Error reading response body:", err)
-	}
-
+// indentJSON pretty prints a JSON body, anything else is returned as is.
+func indentJSON(body []byte) string {
 	finalBody := &bytes.Buffer{}
 	if err := json.Indent(finalBody, body, "", "  "); err != nil {
-		panic(err)
+		return string(body)
 	}
 
 	return finalBody.String()
@@ -0,0 +1,63 @@
+package cligpt
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/eitamonya/cligpt/db"
+)
+
+// Share of a budget spent before warning, when warn_at is not set
+const defaultWarnAt = 0.8
+
+// checkBudget refuses a request once the daily or monthly budget is spent,
+// unless --force is given, and warns when it is nearly spent. cost is the cost
+// of the request when it is known up front, like for an image.
+func (app *appEnv) checkBudget(cost float64) error {
+	budget := app.budget
+	if budget.Daily <= 0 && budget.Monthly <= 0 {
+		return nil
+	}
+
+	warnAt := budget.WarnAt
+	if warnAt <= 0 {
+		warnAt = defaultWarnAt
+	}
+
+	now := time.Now()
+	limits := []struct {
+		name  string
+		limit float64
+		since time.Time
+	}{
+		{"daily", budget.Daily, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)},
+		{"monthly", budget.Monthly, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, l := range limits {
+		if l.limit <= 0 {
+			continue
+		}
+
+		spent, err := db.GetCost(l.since)
+		if err != nil {
+			return err
+		}
+
+		if spent >= l.limit || spent+cost > l.limit {
+			if !app.Force {
+				return fmt.Errorf("%w: $%.2f of the $%.2f %s budget is spent, use --force to go over it", ErrBudget, spent, l.limit, l.name)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: going over the $%.2f %s budget, $%.2f is spent\n", l.limit, l.name, spent)
+			continue
+		}
+
+		if spent+cost >= warnAt*l.limit && !app.budgetWarned {
+			fmt.Fprintf(os.Stderr, "Warning: $%.2f of the $%.2f %s budget is spent\n", spent, l.limit, l.name)
+			app.budgetWarned = true
+		}
+	}
+
+	return nil
+}
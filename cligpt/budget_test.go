@@ -0,0 +1,140 @@
+package cligpt
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// captureStderr returns what f prints to stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	f()
+	w.Close()
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out)
+}
+
+func TestCheckBudget(t *testing.T) {
+	useTempHome(t)
+	// $4 spent today, and so this month
+	if err := db.AddUsage(types.UsageRecord{Model: "gpt-4o", PromptTokens: 1000, Cost: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		budget  Budget
+		cost    float64
+		force   bool
+		warned  bool
+		err     error
+		message string
+	}{
+		{
+			name: "no budget",
+		},
+		{
+			name:   "well under",
+			budget: Budget{Daily: 10, Monthly: 100},
+		},
+		{
+			name:    "nearly spent",
+			budget:  Budget{Daily: 5},
+			message: "Warning: $4.00 of the $5.00 daily budget is spent",
+		},
+		{
+			name:    "nearly spent with the request",
+			budget:  Budget{Daily: 6},
+			cost:    1,
+			message: "Warning: $4.00 of the $6.00 daily budget is spent",
+		},
+		{
+			name:   "warned once",
+			budget: Budget{Daily: 5},
+			warned: true,
+		},
+		{
+			name:   "warn_at",
+			budget: Budget{Daily: 5, WarnAt: 0.9},
+		},
+		{
+			name:    "spent",
+			budget:  Budget{Daily: 4},
+			err:     ErrBudget,
+			message: "$4.00 of the $4.00 daily budget is spent, use --force to go over it",
+		},
+		{
+			name:    "spent with the request",
+			budget:  Budget{Daily: 4.5},
+			cost:    1,
+			err:     ErrBudget,
+			message: "$4.00 of the $4.50 daily budget is spent",
+		},
+		{
+			name:    "monthly spent",
+			budget:  Budget{Daily: 10, Monthly: 3},
+			err:     ErrBudget,
+			message: "$4.00 of the $3.00 monthly budget is spent",
+		},
+		{
+			name:    "forced",
+			budget:  Budget{Daily: 4},
+			force:   true,
+			message: "Warning: going over the $4.00 daily budget, $4.00 is spent",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := &appEnv{budget: test.budget, Force: test.force, budgetWarned: test.warned}
+
+			var err error
+			stderr := captureStderr(t, func() {
+				err = app.checkBudget(test.cost)
+			})
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error = %v, want %v", err, test.err)
+				}
+				if !strings.Contains(err.Error(), test.message) {
+					t.Errorf("error = %q, want it to contain %q", err, test.message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.message == "" && stderr != "" {
+				t.Errorf("printed %q, want nothing", stderr)
+			}
+			if !strings.Contains(stderr, test.message) {
+				t.Errorf("printed %q, want %q", stderr, test.message)
+			}
+			if test.message != "" && !test.force && !app.budgetWarned {
+				t.Error("the warning isn't remembered, it would be repeated")
+			}
+		})
+	}
+}
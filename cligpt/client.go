@@ -0,0 +1,194 @@
+package cligpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	// Upper bound for waits requested by the server through its headers
+	maxServerWait = 2 * time.Minute
+)
+
+// apiClient is the HTTP client shared by the providers. It retries rate
+// limited requests, server errors and transient network errors.
+type apiClient struct {
+	client         http.Client
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func newAPIClient(retry Retry) *apiClient {
+	c := &apiClient{
+		maxRetries:     retry.MaxRetries,
+		initialBackoff: time.Duration(retry.InitialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(retry.MaxBackoffMs) * time.Millisecond,
+	}
+
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.initialBackoff <= 0 {
+		c.initialBackoff = defaultInitialBackoff
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = defaultMaxBackoff
+	}
+
+	return c
+}
+
+func (c *apiClient) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error creating request: %w", err)
+			}
+			req.Body = body
+		}
+
+		resp, err := c.client.Do(req)
+
+		var wait time.Duration
+		var reason string
+		switch {
+		case err != nil && req.Context().Err() != nil:
+			return nil, req.Context().Err()
+		case err != nil:
+			if attempt >= c.maxRetries || !isTransientError(err) {
+				return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+			}
+			wait = c.backoff(attempt)
+			reason = err.Error()
+		case attempt < c.maxRetries && isRetryable(resp):
+			wait = serverWait(resp)
+			if wait == 0 {
+				wait = c.backoff(attempt)
+			}
+			reason = resp.Status
+			// The body has to be read for the connection to be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Request failed (%s), retrying in %s (%d/%d)\n", reason, wait.Round(time.Millisecond), attempt+1, c.maxRetries)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff doubles the wait with every attempt, with up to half of it taken off
+// at random so clients that failed together don't retry together.
+func (c *apiClient) backoff(attempt int) time.Duration {
+	wait := c.initialBackoff << attempt
+	if wait > c.maxBackoff || wait <= 0 {
+		wait = c.maxBackoff
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// isRetryable tells whether a failed request may succeed when it is sent
+// again. A 501 and a 429 for an exhausted quota never will.
+func isRetryable(resp *http.Response) bool {
+	switch code := resp.StatusCode; {
+	case code == http.StatusNotImplemented:
+		return false
+	case code == http.StatusTooManyRequests:
+		return !isQuotaError(resp)
+	default:
+		return code == http.StatusRequestTimeout || code >= 500
+	}
+}
+
+// isQuotaError reads the error of a response, which is put back for
+// checkResponse.
+func isQuotaError(resp *http.Response) bool {
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var envelope apiErrorEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+		return false
+	}
+
+	return envelope.Error.Code == "insufficient_quota" || envelope.Error.Type == "insufficient_quota"
+}
+
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// serverWait reads how long the server asked us to wait from the Retry-After,
+// retry-after-ms and x-ratelimit-reset-* headers, 0 if it didn't say.
+func serverWait(resp *http.Response) time.Duration {
+	var wait time.Duration
+
+	if value := resp.Header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil {
+			wait = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	if wait == 0 {
+		if value := resp.Header.Get("Retry-After"); value != "" {
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				wait = time.Duration(seconds * float64(time.Second))
+			} else if date, err := http.ParseTime(value); err == nil {
+				wait = time.Until(date)
+			}
+		}
+	}
+
+	// OpenAI sends durations like "1s" or "6m0s" for when each limit resets
+	if wait == 0 && resp.StatusCode == http.StatusTooManyRequests {
+		for _, header := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+			if reset, err := time.ParseDuration(resp.Header.Get(header)); err == nil && reset > wait {
+				wait = reset
+			}
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxServerWait {
+		wait = maxServerWait
+	}
+
+	return wait
+}
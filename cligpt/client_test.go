@@ -0,0 +1,260 @@
+package cligpt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	c := newAPIClient(Retry{InitialBackoffMs: 100, MaxBackoffMs: 1000})
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		// The shift overflows
+		{70, time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 50; i++ {
+			wait := c.backoff(test.attempt)
+			if wait < test.max/2 || wait > test.max {
+				t.Fatalf("attempt %d: backoff = %s, want between %s and %s", test.attempt, wait, test.max/2, test.max)
+			}
+		}
+	}
+}
+
+func TestNewAPIClientDefaults(t *testing.T) {
+	tests := []struct {
+		retry      Retry
+		maxRetries int
+	}{
+		{Retry{}, defaultMaxRetries},
+		{Retry{MaxRetries: 5}, 5},
+		{Retry{MaxRetries: -1}, 0},
+	}
+
+	for _, test := range tests {
+		c := newAPIClient(test.retry)
+		if c.maxRetries != test.maxRetries {
+			t.Errorf("%+v: maxRetries = %d, want %d", test.retry, c.maxRetries, test.maxRetries)
+		}
+		if c.initialBackoff != defaultInitialBackoff || c.maxBackoff != defaultMaxBackoff {
+			t.Errorf("%+v: backoff = %s to %s, want the defaults", test.retry, c.initialBackoff, c.maxBackoff)
+		}
+	}
+}
+
+func TestServerWait(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		wait   time.Duration
+	}{
+		{"no headers", 503, nil, 0},
+		{"retry-after seconds", 503, http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{"retry-after fraction", 429, http.Header{"Retry-After": {"0.5"}}, 500 * time.Millisecond},
+		{"retry-after-ms first", 429, http.Header{"Retry-After": {"3"}, "Retry-After-Ms": {"250"}}, 250 * time.Millisecond},
+		{"retry-after in the past", 503, http.Header{"Retry-After": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, 0},
+		{"retry-after invalid", 503, http.Header{"Retry-After": {"soon"}}, 0},
+		{"retry-after capped", 503, http.Header{"Retry-After": {"3600"}}, maxServerWait},
+		{"rate limit resets", 429, http.Header{"X-Ratelimit-Reset-Requests": {"1s"}, "X-Ratelimit-Reset-Tokens": {"6m0s"}}, maxServerWait},
+		{"rate limit resets, longest", 429, http.Header{"X-Ratelimit-Reset-Requests": {"1.5s"}, "X-Ratelimit-Reset-Tokens": {"200ms"}}, 1500 * time.Millisecond},
+		{"rate limit resets only for 429", 503, http.Header{"X-Ratelimit-Reset-Requests": {"1s"}}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: test.status, Header: test.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+
+			if wait := serverWait(resp); wait != test.wait {
+				t.Errorf("wait = %s, want %s", wait, test.wait)
+			}
+		})
+	}
+
+	// A date is turned into the time left until then
+	resp := &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": {time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)}}}
+	if wait := serverWait(resp); wait < 8*time.Second || wait > 10*time.Second {
+		t.Errorf("wait = %s for a date 10s ahead", wait)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		retryable bool
+	}{
+		{http.StatusBadRequest, "", false},
+		{http.StatusUnauthorized, "", false},
+		{http.StatusRequestTimeout, "", true},
+		{http.StatusTooManyRequests, `{"error":{"type":"requests","code":"rate_limit_exceeded","message":"slow down"}}`, true},
+		{http.StatusTooManyRequests, `{"error":{"type":"insufficient_quota","code":"insufficient_quota","message":"You exceeded your current quota"}}`, false},
+		{http.StatusTooManyRequests, "not json", true},
+		{http.StatusInternalServerError, "", true},
+		{http.StatusNotImplemented, "", false},
+		{http.StatusBadGateway, "", true},
+		{529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, true},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Body: io.NopCloser(strings.NewReader(test.body))}
+		if retryable := isRetryable(resp); retryable != test.retryable {
+			t.Errorf("%d %s: retryable = %v, want %v", test.status, test.body, retryable, test.retryable)
+		}
+
+		// The body is still there for checkResponse
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != test.body {
+			t.Errorf("%d: body = %q after the check, want %q", test.status, body, test.body)
+		}
+	}
+}
+
+// replies answers the requests with the given statuses in turn, the last one
+// is repeated. It counts the requests and checks that the body is sent every
+// time.
+func replies(t *testing.T, count *int32, statuses ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(count, 1))
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != `{"prompt":"hi"}` {
+			t.Errorf("request %d: body = %q", n, body)
+		}
+
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("retry-after-ms", "1")
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"message":"reply %d"}}`, n)
+	}
+}
+
+func newTestRequest(t *testing.T, ctx context.Context, url string) *http.Request {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader([]byte(`{"prompt":"hi"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return req
+}
+
+func TestAPIClientDo(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int32
+		status   int
+	}{
+		{"success", []int{200}, 3, 1, 200},
+		{"recovers", []int{503, 502, 200}, 3, 3, 200},
+		{"rate limited", []int{429, 200}, 3, 2, 200},
+		{"gives up", []int{500}, 2, 3, 500},
+		{"retrying off", []int{500}, -1, 1, 500},
+		{"client error", []int{400, 200}, 3, 1, 400},
+		{"not implemented", []int{501, 200}, 3, 1, 501},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var count int32
+			server := httptest.NewServer(replies(t, &count, test.statuses...))
+			defer server.Close()
+
+			c := newAPIClient(Retry{MaxRetries: test.retries, InitialBackoffMs: 1, MaxBackoffMs: 2})
+			resp, err := c.do(newTestRequest(t, context.Background(), server.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, test.status)
+			}
+			if count != test.requests {
+				t.Errorf("%d requests, want %d", count, test.requests)
+			}
+		})
+	}
+}
+
+func TestAPIClientDoQuota(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"type":"insufficient_quota","code":"insufficient_quota","message":"You exceeded your current quota"}}`)
+	}))
+	defer server.Close()
+
+	c := newAPIClient(Retry{InitialBackoffMs: 1, MaxBackoffMs: 2})
+	resp, err := c.do(newTestRequest(t, context.Background(), server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if count != 1 {
+		t.Errorf("%d requests, a quota error shouldn't be retried", count)
+	}
+	err = checkResponse(resp)
+	if !errors.Is(err, ErrRateLimited) || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("error = %v, want the quota error", err)
+	}
+}
+
+func TestAPIClientDoCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := newAPIClient(Retry{}).do(newTestRequest(t, ctx, server.URL))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the context error", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the wait for the retry wasn't cut short")
+	}
+}
+
+func TestAPIClientDoNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	_, err := newAPIClient(Retry{InitialBackoffMs: 1, MaxBackoffMs: 2}).do(newTestRequest(t, context.Background(), url))
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("error = %v, want ErrNetwork", err)
+	}
+}
@@ -2,14 +2,15 @@ package cligpt
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
 	"strings"
 
+	"github.com/chzyer/readline"
 	"github.com/eitamonya/cligpt/types"
 
 	"github.com/eitamonya/cligpt/db"
@@ -18,37 +19,61 @@ import (
 const clearScreen string = "\033[H\033[2J"
 const responseColor string = "\x1b[%dm%s\x1b[0m"
 
-type Chunk struct {
-	Choices []struct {
-		FinishReason string `json:"finish_reason"`
-		Delta        struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"delta"`
-	} `json:"choices"`
-}
-
 type appEnv struct {
-	model          string
-	token          string
-	OutputJSON     bool
-	isSinglePrompt bool
+	model      string
+	provider   Provider
+	OutputJSON bool
+	// Force goes over the budget
+	Force          bool
+	Stream         bool
+	Files          []string
+	Images         []string
+	BaseURL        string
 	InitialPrompt  string
 	temperature    float64
 	max_tokens     int
-	personality    string
-	listSessions   bool
-	sessions       []types.Session
-	currentSession types.Session
-	image          Image
+	context_window int
+	// context_strategy is one of drop, summarize or error
+	context_strategy    string
+	summarize_threshold int
+	prices              map[string]Price
+	image_prices        map[string]float64
+	budget              Budget
+	budgetWarned        bool
+	personality         string
+	listSessions        bool
+	currentSession      types.Session
+	image               Image
+	stdin               string
+	// stdinPiped is set once the piped input is read, the chat input then
+	// comes from tty
+	stdinPiped bool
+	tty        *os.File
+	input      *bufio.Scanner
+	// line edits the chat input when it comes from a terminal
+	line *readline.Instance
+	// modelNames are the models offered by tab completion
+	modelNames []string
 }
 
-func (app *appEnv) loadConfig() {
-	config := parseConfig()
+func (app *appEnv) loadConfig() error {
+	config, err := parseConfig()
+	if err != nil {
+		return err
+	}
 
-	app.token = config.Token
+	if app.BaseURL != "" {
+		config.BaseURL = app.BaseURL
+	}
 
-	if config.Model == "" {
+	app.provider, err = newProvider(config)
+	if err != nil {
+		return err
+	}
+
+	if config.Model == "" && config.Provider == "anthropic" {
+		app.model = models["claude"]
+	} else if config.Model == "" {
 		app.model = models["chatgpt"]
 	} else {
 		app.model = config.Model
@@ -68,13 +93,37 @@ func (app *appEnv) loadConfig() {
 	app.personality = personality
 	app.temperature = config.Temperature
 	app.max_tokens = config.MaxTokens
+	app.context_window = config.ContextWindow
+	app.summarize_threshold = config.SummarizeThreshold
+	app.prices = config.Prices
+	app.image_prices = config.ImagePrices
+
+	if config.Budget.Daily < 0 || config.Budget.Monthly < 0 || config.Budget.WarnAt < 0 || config.Budget.WarnAt > 1 {
+		return fmt.Errorf("%w: budget limits must be positive and warn_at between 0 and 1", ErrConfig)
+	}
+	app.budget = config.Budget
+
+	switch config.ContextStrategy {
+	case "":
+		app.context_strategy = contextStrategyDrop
+	case contextStrategyDrop, contextStrategySummarize, contextStrategyError:
+		app.context_strategy = config.ContextStrategy
+	default:
+		return fmt.Errorf("%w: context_strategy must be drop, summarize or error", ErrConfig)
+	}
+
+	return nil
 }
 
-func getUserInput() string {
-	fmt.Print("> ")
-	input := bufio.NewScanner(os.Stdin)
-	input.Scan()
-	return input.Text()
+// IsTerminal reports whether f is an interactive terminal rather than a pipe
+// or a file.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
 }
 
 func createMessage(role string, content string) types.Message {
@@ -85,112 +134,246 @@ func printResponse(responseString string) {
 	fmt.Print(fmt.Sprintf(responseColor, 32, responseString))
 }
 
-func regExpChunk(line []byte) []string {
-	pat := regexp.MustCompile(`(data: )(.*)`)
-	return pat.FindStringSubmatch(string(line))
-}
+func (app *appEnv) singlePrompt() error {
+	fmt.Print(clearScreen)
 
-func parseMessageChunks(resp *http.Response) string {
-	var content string
+	request, err := app.completionRequest(context.Background())
+	if err != nil {
+		return err
+	}
 
-	if resp.StatusCode != 200 {
-		log.Fatal(stringifyResponseBody(resp))
+	response, err := app.provider.Complete(context.Background(), request)
+	if err != nil {
+		return err
 	}
+	app.recordUsage(request, response)
 
-	reader := bufio.NewReader(resp.Body)
-out:
-	for {
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			fmt.Println(err)
-			break
-		}
+	if app.OutputJSON {
+		printResponse(indentJSON(response.Raw))
+		print()
+		return nil
+	}
 
-		var chunk Chunk
+	printResponse(response.Content)
+	print()
 
-		matches := regExpChunk(line)
+	return nil
+}
 
-		if len(matches) > 1 {
-			if err := json.Unmarshal([]byte(strings.Trim(matches[2], " ")), &chunk); err != nil {
-				log.Fatal("Error parsing response body:", err)
-			}
-			if chunk.Choices[0].Delta.Content != "" {
-				fmt.Print(fmt.Sprintf(responseColor, 32, chunk.Choices[0].Delta.Content))
-				content += chunk.Choices[0].Delta.Content
-			}
+type streamEvent struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
 
-			if chunk.Choices[0].FinishReason == "stop" {
-				break out
-			}
+func printStreamEvent(eventType string, content string) {
+	line, _ := json.Marshal(streamEvent{Type: eventType, Content: content})
+	fmt.Println(string(line))
+}
+
+// streamPrompt prints a single answer as it is generated. With --json every
+// delta is printed as a line of JSON, followed by a "done" line holding the
+// whole answer.
+func (app *appEnv) streamPrompt() error {
+	onDelta := printResponse
+	if app.OutputJSON {
+		onDelta = func(delta string) {
+			printStreamEvent("delta", delta)
 		}
+	} else {
+		fmt.Print(clearScreen)
 	}
 
-	return content
-}
-
-func (app *appEnv) singlePrompt() {
-	fmt.Print(clearScreen)
-	req := buildCompletionRequest(app)
+	request, err := app.completionRequest(context.Background())
+	if err != nil {
+		return err
+	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	response, err := app.provider.Stream(context.Background(), request, onDelta)
 	if err != nil {
-		log.Fatal("Error sending request:", err)
+		return err
 	}
-	defer resp.Body.Close()
+	app.recordUsage(request, response)
 
 	if app.OutputJSON {
-		printResponse(stringifyResponseBody(resp))
-		print()
-		return
+		printStreamEvent("done", response.Content)
+	} else {
+		fmt.Println()
 	}
 
-	responseBody := parseCompletionResponse(resp)
-
-	printResponse(responseBody.Choices[0].Message.Content)
-	print()
+	return nil
 }
 
-func (app *appEnv) sessionPrompt() {
+// sessionPrompt asks for an answer to the current session. added is the
+// number of messages added to it for this answer, they are taken back when it
+// is cancelled or fails before anything arrives.
+func (app *appEnv) sessionPrompt(added int) error {
 	fmt.Print(clearScreen)
 
-	req := buildCompletionRequest(app)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal("Error sending request:", err)
+	stopInterrupt := cancelOnInterrupt(cancel)
+	err := app.rollSummary(ctx)
+	var request CompletionRequest
+	if err == nil {
+		request, err = app.completionRequest(ctx)
+	}
+	var response CompletionResponse
+	if err == nil {
+		response, err = app.provider.Stream(ctx, request, printResponse)
+	}
+	stopInterrupt()
+	content := response.Content
+
+	cancelled := errors.Is(err, context.Canceled)
+	if err != nil && content == "" {
+		// Nothing to keep, forget the new question as well so it can be
+		// asked again. They aren't saved yet, a question asked again with
+		// /retry stays as it is.
+		messages := app.currentSession.Messages
+		app.currentSession.Messages = messages[:len(messages)-added]
+		if cancelled {
+			fmt.Println("Generation cancelled")
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		return nil
+	}
+	// Keep what was received before the stream stopped
+	if cancelled {
+		fmt.Println("\n[cancelled]")
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "\nThe answer was cut off, keeping the partial answer:", err)
 	}
-	defer resp.Body.Close()
 
-	content := parseMessageChunks(resp)
+	usage := app.responseUsage(request, response)
+	app.currentSession.Messages = append(app.currentSession.Messages, types.Message{
+		Role:      "assistant",
+		Content:   content,
+		Truncated: err != nil,
+		Model:     request.Model,
+		Tokens:    usage.CompletionTokens,
+	})
+
+	if err := app.saveSession(); err != nil {
+		return err
+	}
+	app.saveUsage(request.Model, usage)
 
-	app.currentSession.Messages = append(app.currentSession.Messages, types.Message{Role: "assistant", Content: content})
+	fmt.Println()
+
+	return nil
+}
+
+// saveSession saves the messages added to the current session since it was
+// last saved, a new session is created on the first save.
+func (app *appEnv) saveSession() error {
+	messages := app.currentSession.Messages
+	saved := len(messages)
+	for saved > 0 && messages[saved-1].ID == 0 {
+		saved--
+	}
+	unsaved := messages[saved:]
+	if len(unsaved) == 0 {
+		return nil
+	}
+
+	counts, err := messageTokens(app.model, unsaved)
+	if err != nil {
+		return err
+	}
+	for i := range unsaved {
+		if unsaved[i].Tokens == 0 {
+			unsaved[i].Tokens = counts[i]
+		}
+	}
+
+	parentID := 0
+	if saved > 0 {
+		parentID = messages[saved-1].ID
+	}
 
 	if app.currentSession.ID == 0 {
-		app.currentSession = db.CreateSession(app.currentSession.Messages)
-	} else {
-		db.UpdateSession(app.currentSession.ID, app.currentSession.Messages)
+		session, err := db.CreateSession(app.currentSession.ParentID, parentID, unsaved)
+		if err != nil {
+			return err
+		}
+		copy(unsaved, session.Messages)
+		app.currentSession.ID = session.ID
+		app.currentSession.ForkedAt = session.ForkedAt
+
+		if app.currentSession.Title != "" {
+			if err := db.RenameSession(session.ID, app.currentSession.Title); err != nil {
+				return err
+			}
+		}
+
+		if app.currentSession.Summary == "" {
+			return nil
+		}
+		return db.SaveSummary(session.ID, app.currentSession.Summary, app.currentSession.SummaryUpTo)
 	}
 
-	fmt.Println()
+	appended, err := db.AppendMessages(app.currentSession.ID, parentID, unsaved)
+	if err != nil {
+		return err
+	}
+	copy(unsaved, appended)
+
+	return nil
+}
+
+// cancelOnInterrupt cancels the running generation on Ctrl-C, a second Ctrl-C
+// exits. The returned function restores the default handling.
+func cancelOnInterrupt(cancel context.CancelFunc) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-signals:
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
 }
 
-func Init() {
-	createConfig()
-	db.InitDB()
-	SelectAndSaveModel()
-	GetAndSaveToken()
+func Init() error {
+	if err := createConfig(); err != nil {
+		return err
+	}
+	if err := db.InitDB(); err != nil {
+		return err
+	}
+	if _, err := selectModel(); err != nil {
+		return err
+	}
+	return GetAndSaveToken()
 }
 
-func InitApp() appEnv {
+func InitApp() (appEnv, error) {
 	app := appEnv{}
-	app.loadConfig()
-	return app
+	err := app.loadConfig()
+	return app, err
 }
 
-func (app *appEnv) Chat() {
-	app.loadConfig()
+func (app *appEnv) Chat() error {
+	if err := app.loadConfig(); err != nil {
+		return err
+	}
 
 	if app.currentSession.ID == 0 {
 		app.currentSession = types.Session{Messages: []types.Message{}}
@@ -200,84 +383,177 @@ func (app *appEnv) Chat() {
 		app.currentSession.Messages = append(app.currentSession.Messages, createMessage("system", app.personality))
 	}
 
+	defer app.closeInput()
+
+	// Files given with --file, --image and /add go with the next message
 	for true {
 		var input string
-		if app.InitialPrompt != "" {
-			input = app.InitialPrompt
+		if app.InitialPrompt != "" || app.stdin != "" {
+			input = withStdin(app.InitialPrompt, app.stdin)
 			app.InitialPrompt = ""
+			app.stdin = ""
 		} else {
-			input = getUserInput()
+			var ok bool
+			if input, ok = app.getUserInput(); !ok {
+				break
+			}
 		}
 
 		if input == "exit" || input == "quit" || input == "q" {
 			break
 		}
 
-		app.currentSession.Messages = append(app.currentSession.Messages, createMessage("user", input))
-		app.sessionPrompt()
+		retry := false
+		added := 0
+		if strings.HasPrefix(input, "//") {
+			input = input[1:]
+		} else if strings.HasPrefix(input, "/") {
+			text, err := app.runCommand(input)
+			if errors.Is(err, errQuitChat) {
+				break
+			}
+			retry = errors.Is(err, errRetry)
+			if err != nil && !retry {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				continue
+			}
+			if text == "" && !retry {
+				continue
+			}
+			input = text
+		}
+
+		if !retry {
+			message, err := newUserMessage(input, app.Files, app.Images)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				continue
+			}
+			app.Files = nil
+			app.Images = nil
+
+			if err := app.checkBudget(0); err != nil {
+				return err
+			}
+			app.currentSession.Messages = append(app.currentSession.Messages, message)
+			added = 1
+		} else if err := app.checkBudget(0); err != nil {
+			return err
+		}
+
+		if err := app.sessionPrompt(added); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (app *appEnv) SinglePrompt() {
-	app.loadConfig()
-	app.isSinglePrompt = true
+func (app *appEnv) SinglePrompt() error {
+	if err := app.loadConfig(); err != nil {
+		return err
+	}
 	app.currentSession = types.Session{Messages: []types.Message{}}
-	app.currentSession.Messages = append(app.currentSession.Messages, createMessage("user", app.InitialPrompt))
-	app.singlePrompt()
+	message, err := newUserMessage(withStdin(app.InitialPrompt, app.stdin), app.Files, app.Images)
+	if err != nil {
+		return err
+	}
+	app.currentSession.Messages = append(app.currentSession.Messages, message)
+	if err := app.checkBudget(0); err != nil {
+		return err
+	}
+	if app.Stream {
+		return app.streamPrompt()
+	}
+	return app.singlePrompt()
 }
 
-func (app *appEnv) ListAndSelectSession() {
-	app.loadConfig()
+func (app *appEnv) ListAndSelectSession() error {
+	if err := app.loadConfig(); err != nil {
+		return err
+	}
 	app.listSessions = true
-	app.sessions = db.GetLastTenSessions()
-
-	sessionNames := []string{}
-	for _, e := range app.sessions {
-		var name string
 
-		if e.Messages[0].Role == "system" {
-			name = e.Messages[1].Content
-		} else {
-			name = e.Messages[0].Content
-		}
+	sessions, _, err := db.ListSessions(pickerSessions, 0)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return db.ErrNoSessions
+	}
 
-		if len(name) > 90 {
-			name = strings.TrimSpace(name[:90]) + "..."
-		}
+	// Forks are listed under the session they were made from
+	sessions, depths := sessionTree(sessions)
 
-		sessionNames = append(sessionNames, name)
+	// The ID keeps the names unique, the picker finds the selected value by
+	// its name
+	sessionNames := []string{}
+	for i, e := range sessions {
+		sessionNames = append(sessionNames, treeLine(e, depths[i]))
 	}
 
 	selectSessionPromptContent := promptSelectContent{
-		label:        "Select a previous chat",
+		label:        "Select a previous chat, press / to search",
 		selectValues: sessionNames,
+		searcher:     sessionSearcher(sessions, sessionNames),
+		size:         10,
+	}
+	promptResult, err := promptGetSelect(selectSessionPromptContent)
+	if err != nil {
+		return err
 	}
-	promptResult := promptGetSelect(selectSessionPromptContent)
 
-	app.currentSession = app.sessions[promptResult.index]
-	app.sessions = nil
-	for _, e := range app.currentSession.Messages {
-		if e.Role == "user" {
-			fmt.Println("USER: ", e.Content+"\n")
-		} else if e.Role == "system" {
-			fmt.Println("SYSTEM: ", e.Content+"\n")
-		} else {
-			printResponse(e.Content + "\n")
-		}
+	app.currentSession, err = db.GetSession(sessions[promptResult.index].ID)
+	if err != nil {
+		return err
 	}
+	printSession(app.currentSession)
+
+	return nil
 }
 
-func (app *appEnv) GenerateImage() {
+func (app *appEnv) GenerateImage() error {
+	if err := app.loadConfig(); err != nil {
+		return err
+	}
+
+	generator, ok := app.provider.(ImageGenerator)
+	if !ok {
+		return fmt.Errorf("image generation: %w", ErrUnsupported)
+	}
+
+	image := app.image.withDefaults()
+	if err := app.checkBudget(app.imagePrice(image)); err != nil {
+		return err
+	}
+
 	fmt.Print(clearScreen)
-	req := buildImageRequest(app)
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	body, err := generator.GenerateImage(context.Background(), app.InitialPrompt, image)
 	if err != nil {
-		log.Fatal("Error sending request:", err)
+		return err
 	}
-	defer resp.Body.Close()
+	app.recordImage(image)
 
-	printResponse(stringifyResponseBody(resp))
+	printResponse(indentJSON(body))
 	print()
+
+	return nil
+}
+
+func (app *appEnv) ListModels() error {
+	if err := app.loadConfig(); err != nil {
+		return err
+	}
+
+	ids, err := app.provider.ListModels(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, model := range ids {
+		fmt.Println(model)
+	}
+
+	return nil
 }
@@ -0,0 +1,550 @@
+package cligpt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// chatCommand is a slash command of the chat.
+type chatCommand struct {
+	name string
+	// usage shows the arguments in /help, save is set when the setting can
+	// be written to the config with --save
+	usage string
+	help  string
+	save  bool
+	// complete returns the values the last argument can take, for tab
+	// completion
+	complete func(app *appEnv, word string) []string
+	// run handles the command. It returns a message to send to the model,
+	// or errRetry or errQuitChat to act on the chat.
+	run func(app *appEnv, args string) (string, error)
+}
+
+var (
+	// errRetry asks again for an answer to the last message
+	errRetry = errors.New("retry")
+	// errQuitChat ends the chat
+	errQuitChat = errors.New("quit")
+)
+
+// chatCommands are filled in by init, /help lists them
+var chatCommands []chatCommand
+
+func init() {
+	chatCommands = []chatCommand{
+		{name: "help", help: "List the commands", run: helpCommand},
+		{name: "model", usage: "[name]", save: true, help: "Show or switch the model", complete: completeModels, run: modelCommand},
+		{name: "temp", usage: "[0-1]", save: true, help: "Show or set the sampling temperature", run: tempCommand},
+		{name: "persona", usage: "[name]", save: true, help: "Show the personalities or switch to one", complete: completePersonas, run: personaCommand},
+		{name: "system", usage: "[message]", help: "Add a system message to the chat, or show them", run: systemCommand},
+		{name: "add", usage: "<path>...", help: "Attach files to your next message", complete: completeFiles, run: addCommand},
+		{name: "retry", help: "Ask again for the last answer", run: retryCommand},
+		{name: "undo", help: "Remove the last question and its answer", run: undoCommand},
+		{name: "edit", usage: "<n> [message]", help: "Fork the chat before turn n and send a new message instead", run: func(app *appEnv, args string) (string, error) {
+			return app.editCommand(args)
+		}},
+		{name: "fork", usage: "[n]", help: "Continue the chat in a fork after turn n, the last one by default", run: func(app *appEnv, args string) (string, error) {
+			return "", app.forkCommand(args)
+		}},
+		{name: "clear", help: "Start a new chat, the current one stays saved", run: clearCommand},
+		{name: "save", usage: "[file]", help: "Save the chat now, and write it to a .md, .html, .json or .jsonl file", complete: completeFiles, run: saveCommand},
+		{name: "title", usage: "[title]", help: "Show or set the title the chat is listed under", run: titleCommand},
+		{name: "tokens", help: "Show the size of the chat and the room left in the context window", run: tokensCommand},
+		{name: "copy", usage: "[n]", help: "Copy the last answer, or the answer of turn n, to the clipboard", run: copyCommand},
+		{name: "exit", help: "End the chat, like exit, quit or q", run: func(app *appEnv, args string) (string, error) {
+			return "", errQuitChat
+		}},
+	}
+}
+
+func findCommand(name string) (chatCommand, bool) {
+	for _, command := range chatCommands {
+		if "/"+command.name == name {
+			return command, true
+		}
+	}
+
+	return chatCommand{}, false
+}
+
+// runCommand runs the slash command the input starts with.
+func (app *appEnv) runCommand(input string) (string, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(input), " ")
+	command, ok := findCommand(name)
+	if !ok {
+		return "", fmt.Errorf("unknown command %s, type /help for the list, start a message with // to send it as is", name)
+	}
+
+	return command.run(app, strings.TrimSpace(args))
+}
+
+// cutSave removes the --save flag from the arguments.
+func cutSave(args string) (string, bool) {
+	var kept []string
+	save := false
+	for _, field := range strings.Fields(args) {
+		if field == "--save" {
+			save = true
+		} else {
+			kept = append(kept, field)
+		}
+	}
+
+	return strings.Join(kept, " "), save
+}
+
+func helpCommand(app *appEnv, args string) (string, error) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, command := range chatCommands {
+		usage := "/" + command.name
+		if command.usage != "" {
+			usage += " " + command.usage
+		}
+		if command.save {
+			usage += " [--save]"
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", usage, command.help)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	fmt.Println("\nSettings apply to this chat only, --save writes them to the config. Press Tab to complete a command.")
+
+	return "", nil
+}
+
+func modelCommand(app *appEnv, args string) (string, error) {
+	model, save := cutSave(args)
+	if model == "" {
+		fmt.Println("Model:", app.model)
+		return "", nil
+	}
+	if strings.Contains(model, " ") {
+		return "", fmt.Errorf("invalid model %q", model)
+	}
+
+	app.model = model
+	fmt.Println("Switched to", model)
+	if save {
+		return "", saveToConfig("model", model)
+	}
+
+	return "", nil
+}
+
+// completeModels offers the models of the provider, which are fetched on the
+// first Tab, or the models of the price table when they can't be.
+func completeModels(app *appEnv, word string) []string {
+	if app.modelNames == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		names, err := app.provider.ListModels(ctx)
+		if err != nil || len(names) == 0 {
+			names = []string{app.model}
+			for name := range prices {
+				if name != app.model {
+					names = append(names, name)
+				}
+			}
+		}
+		sort.Strings(names)
+		app.modelNames = names
+	}
+
+	return append(append([]string{}, app.modelNames...), "--save")
+}
+
+func tempCommand(app *appEnv, args string) (string, error) {
+	value, save := cutSave(args)
+	if value == "" {
+		fmt.Println("Temperature:", strconv.FormatFloat(app.temperature, 'f', -1, 64))
+		return "", nil
+	}
+
+	temperature, err := strconv.ParseFloat(value, 64)
+	if err != nil || temperature < 0 || temperature > 1 {
+		return "", fmt.Errorf("invalid temperature %q, use a number between 0 and 1", value)
+	}
+
+	app.temperature = temperature
+	fmt.Println("Temperature set to", value)
+	if save {
+		return "", saveToConfig("temperature", value)
+	}
+
+	return "", nil
+}
+
+func completePersonas(app *appEnv, word string) []string {
+	config, err := parseConfig()
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, personality := range config.Personalities {
+		names = append(names, personality.Name)
+	}
+
+	return append(names, "--save")
+}
+
+// personaCommand switches the persona. It takes the place of the persona at
+// the start of a chat that has no messages yet, later it is added as a
+// system message.
+func personaCommand(app *appEnv, args string) (string, error) {
+	name, save := cutSave(args)
+	config, err := parseConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		for _, personality := range config.Personalities {
+			marker := " "
+			if personality.Context == app.personality {
+				marker = "*"
+			}
+			fmt.Printf("%s %s: %s\n", marker, personality.Name, personality.Context)
+		}
+		return "", nil
+	}
+
+	var persona *Personality
+	for i := range config.Personalities {
+		if config.Personalities[i].Name == name {
+			persona = &config.Personalities[i]
+		}
+	}
+	if persona == nil {
+		return "", fmt.Errorf("there is no personality named %q, type /persona to list them", name)
+	}
+
+	messages := app.currentSession.Messages
+	if app.currentSession.ID == 0 && leadingSystemMessages(messages) == len(messages) {
+		app.currentSession.Messages = []types.Message{createMessage("system", persona.Context)}
+	} else {
+		app.currentSession.Messages = append(messages, createMessage("system", persona.Context))
+	}
+	app.personality = persona.Context
+	fmt.Println("Switched to", name)
+
+	if save {
+		return "", saveToConfig("persona", name)
+	}
+
+	return "", nil
+}
+
+func systemCommand(app *appEnv, args string) (string, error) {
+	if args == "" {
+		for _, message := range app.currentSession.Messages {
+			if message.Role == "system" {
+				fmt.Println("SYSTEM: ", message.Content)
+			}
+		}
+		return "", nil
+	}
+
+	app.currentSession.Messages = append(app.currentSession.Messages, createMessage("system", args))
+	fmt.Println("The system message will be sent with your next message")
+
+	return "", nil
+}
+
+// addCommand attaches files to the next message, like --file does to the
+// first one.
+func addCommand(app *appEnv, args string) (string, error) {
+	if args == "" {
+		return "", fmt.Errorf("give the paths of the files to attach")
+	}
+
+	paths, err := expandFilePatterns(strings.Fields(args))
+	if err != nil {
+		return "", err
+	}
+	app.Files = append(app.Files, paths...)
+	fmt.Println("Attached " + strings.Join(paths, ", ") + ", the files will be sent with your next message")
+
+	return "", nil
+}
+
+func completeFiles(app *appEnv, word string) []string {
+	matches, _ := filepath.Glob(word + "*")
+
+	var names []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			match += string(filepath.Separator)
+		}
+		names = append(names, match)
+	}
+
+	return names
+}
+
+// truncateSession drops the messages of the current session from keep on.
+// Saved messages are deleted, unless a fork shares them, then the chat goes
+// on in a new fork instead.
+func (app *appEnv) truncateSession(keep int) error {
+	session := app.currentSession
+	if keep >= len(session.Messages) {
+		return nil
+	}
+
+	if session.Messages[keep].ID != 0 {
+		// The messages up to ForkedAt belong to the session it was forked from
+		shared := 0
+		for i, message := range session.Messages {
+			if session.ForkedAt != 0 && message.ID == session.ForkedAt {
+				shared = i + 1
+			}
+		}
+
+		err := db.ErrSharedMessages
+		if keep >= shared {
+			err = db.DeleteMessages(session.ID, session.Messages[keep].ID)
+		}
+		if errors.Is(err, db.ErrSharedMessages) {
+			fork, err := forkSession(session, keep)
+			if err != nil {
+				return err
+			}
+			app.currentSession = fork
+			fmt.Printf("The messages are shared with another session, the chat goes on in session %d, forked from %d\n", fork.ID, session.ID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	app.currentSession.Messages = session.Messages[:keep]
+	if session.SummaryUpTo > keep {
+		app.currentSession.Summary, app.currentSession.SummaryUpTo = "", 0
+		if session.ID != 0 {
+			return db.SaveSummary(session.ID, "", 0)
+		}
+	}
+
+	return nil
+}
+
+func retryCommand(app *appEnv, args string) (string, error) {
+	messages := app.currentSession.Messages
+	last := len(messages) - 1
+	for last >= 0 && messages[last].Role != "user" {
+		last--
+	}
+	if last < 0 {
+		return "", fmt.Errorf("there is nothing to retry")
+	}
+
+	// System messages added since stay for the new answer
+	var kept []types.Message
+	for _, message := range messages[last+1:] {
+		if message.Role != "assistant" {
+			message.ID, message.ParentID = 0, 0
+			kept = append(kept, message)
+		}
+	}
+
+	if err := app.truncateSession(last + 1); err != nil {
+		return "", err
+	}
+	app.currentSession.Messages = append(app.currentSession.Messages, kept...)
+
+	return "", errRetry
+}
+
+func undoCommand(app *appEnv, args string) (string, error) {
+	messages := app.currentSession.Messages
+	turns := countTurns(messages)
+	if turns == 0 {
+		return "", fmt.Errorf("there is nothing to undo")
+	}
+
+	start, err := turnStart(messages, turns)
+	if err != nil {
+		return "", err
+	}
+	if err := app.truncateSession(start); err != nil {
+		return "", err
+	}
+	fmt.Printf("Removed turn %d\n", turns)
+
+	return "", nil
+}
+
+func clearCommand(app *appEnv, args string) (string, error) {
+	previous := app.currentSession.ID
+
+	app.currentSession = types.Session{Messages: []types.Message{}}
+	if app.personality != "" {
+		app.currentSession.Messages = append(app.currentSession.Messages, createMessage("system", app.personality))
+	}
+	app.Files, app.Images = nil, nil
+
+	fmt.Print(clearScreen)
+	if previous != 0 {
+		fmt.Printf("Started a new chat, the last one is saved as session %d\n", previous)
+	} else {
+		fmt.Println("Started a new chat")
+	}
+
+	return "", nil
+}
+
+func saveCommand(app *appEnv, args string) (string, error) {
+	if countTurns(app.currentSession.Messages) == 0 {
+		return "", fmt.Errorf("there is nothing to save yet")
+	}
+	if err := app.saveSession(); err != nil {
+		return "", err
+	}
+
+	if args == "" {
+		fmt.Printf("Saved session %d\n", app.currentSession.ID)
+		return "", nil
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(args), ".")
+	if _, ok := exportFormats[format]; !ok {
+		return "", fmt.Errorf("can't tell the format of %s, use a .md, .html, .json or .jsonl file", args)
+	}
+	session, err := db.GetSession(app.currentSession.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := writeExportFile(args, format, session); err != nil {
+		return "", err
+	}
+	fmt.Printf("Saved session %d to %s\n", session.ID, args)
+
+	return "", nil
+}
+
+func titleCommand(app *appEnv, args string) (string, error) {
+	if args == "" {
+		if app.currentSession.Title == "" {
+			fmt.Println("The chat has no title, it is listed under its first message")
+		} else {
+			fmt.Println("Title:", app.currentSession.Title)
+		}
+		return "", nil
+	}
+
+	app.currentSession.Title = args
+	// A new chat gets its title when it is saved
+	if app.currentSession.ID != 0 {
+		if err := db.RenameSession(app.currentSession.ID, args); err != nil {
+			return "", err
+		}
+	}
+	fmt.Println("Title set to", args)
+
+	return "", nil
+}
+
+func tokensCommand(app *appEnv, args string) (string, error) {
+	total, err := countTokens(app.model, app.currentSession.Messages)
+	if err != nil {
+		return "", err
+	}
+	sent, err := countTokens(app.model, app.requestMessages())
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("The chat is %d tokens in %d messages", total, len(app.currentSession.Messages))
+	if sent != total {
+		fmt.Printf(", %d with the summary of the oldest %d", sent, app.currentSession.SummaryUpTo)
+	}
+	fmt.Println()
+	fmt.Printf("The context window of %s is %d tokens, %d are set aside for the answer and %d are left\n",
+		app.model, app.contextWindow(), app.replyTokens(), app.contextWindow()-app.replyTokens()-sent)
+
+	return "", nil
+}
+
+func copyCommand(app *appEnv, args string) (string, error) {
+	messages := app.currentSession.Messages
+	turn := countTurns(messages)
+	if args != "" {
+		var err error
+		if turn, err = parseTurn(args); err != nil {
+			return "", err
+		}
+	}
+
+	start, err := turnStart(messages, turn)
+	if err != nil || turn == 0 {
+		return "", fmt.Errorf("there is no answer to copy")
+	}
+	end, _ := turnEnd(messages, turn)
+
+	var answers []string
+	for _, message := range messages[start:end] {
+		if message.Role == "assistant" {
+			answers = append(answers, message.Content)
+		}
+	}
+	if len(answers) == 0 {
+		return "", fmt.Errorf("turn %d has no answer", turn)
+	}
+
+	if err := copyToClipboard(strings.Join(answers, "\n\n")); err != nil {
+		return "", err
+	}
+	fmt.Printf("Copied the answer of turn %d\n", turn)
+
+	return "", nil
+}
+
+// Clipboard commands, tried in order
+var clipboardCommands = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// copyToClipboard copies text with the first clipboard command found. Without
+// one the terminal is asked to copy it with an OSC 52 sequence, which works
+// over SSH as well.
+func copyToClipboard(text string) error {
+	for _, command := range clipboardCommands {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err == nil {
+			return nil
+		}
+	}
+
+	if !IsTerminal(os.Stdout) {
+		return fmt.Errorf("no clipboard found")
+	}
+	fmt.Printf("\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+
+	return nil
+}
@@ -20,6 +20,20 @@ const (
 var models = map[string]string{
 	"chatgpt": "gpt-3.5-turbo",
 	"gpt4":    "gpt-4",
+	"claude":  "claude-sonnet-4-5",
+}
+
+// Providers of the models in the picker
+var modelProviders = map[string]string{
+	"chatgpt": "openai",
+	"gpt4":    "openai",
+	"claude":  "anthropic",
+}
+
+// Names of the providers in prompts
+var providerNames = map[string]string{
+	"openai":    "OpenAI",
+	"anthropic": "Anthropic",
 }
 
 type Personality struct {
@@ -35,47 +49,87 @@ type Image struct {
 	Style   string `yaml:"style"`
 }
 
+// withDefaults returns the settings used when the image section of the config
+// is left out.
+func (image Image) withDefaults() Image {
+	if image != (Image{}) {
+		return image
+	}
+
+	return Image{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "vivid"}
+}
+
+// Budget limits the spending in USD, 0 means no limit. A warning is printed
+// once WarnAt of a limit is spent, e.g. 0.8 for 80%, and requests are refused
+// once it is reached.
+type Budget struct {
+	Daily   float64 `yaml:"daily"`
+	Monthly float64 `yaml:"monthly"`
+	WarnAt  float64 `yaml:"warn_at,omitempty"`
+}
+
+// Retry controls how failed requests are retried. Zero values use the
+// defaults, a negative max_retries turns retrying off.
+type Retry struct {
+	MaxRetries       int `yaml:"max_retries"`
+	InitialBackoffMs int `yaml:"initial_backoff_ms"`
+	MaxBackoffMs     int `yaml:"max_backoff_ms"`
+}
+
 type Config struct {
-	Model         string        `yaml:"model"`
-	Token         string        `yaml:"token"`
-	Personalities []Personality `yaml:"personalities"`
-	Temperature   float64       `yaml:"temperature"`
-	MaxTokens     int           `yaml:"max_tokens"`
-	Image         Image         `yaml:"image"`
+	Provider      string            `yaml:"provider"`
+	BaseURL       string            `yaml:"base_url,omitempty"`
+	Headers       map[string]string `yaml:"headers,omitempty"`
+	Model         string            `yaml:"model"`
+	Token         string            `yaml:"token"`
+	Personalities []Personality     `yaml:"personalities"`
+	Temperature   float64           `yaml:"temperature"`
+	MaxTokens     int               `yaml:"max_tokens"`
+	Image         Image             `yaml:"image"`
+	Retry         Retry             `yaml:"retry,omitempty"`
+	// ContextWindow overrides the context window size of the model
+	ContextWindow int `yaml:"context_window,omitempty"`
+	// ContextStrategy is what happens when a chat outgrows the context
+	// window: drop, summarize or error
+	ContextStrategy string `yaml:"context_strategy,omitempty"`
+	// SummarizeThreshold is the size in tokens past which the oldest turns
+	// of a chat are replaced by a summary, 0 turns it off
+	SummarizeThreshold int `yaml:"summarize_threshold,omitempty"`
+	// Prices add to and override the built-in prices, keyed by model prefix
+	Prices map[string]Price `yaml:"prices,omitempty"`
+	// ImagePrices add to and override the built-in prices of a generated
+	// image, keyed by "<model> <quality> <size>"
+	ImagePrices map[string]float64 `yaml:"image_prices,omitempty"`
+	Budget      Budget             `yaml:"budget,omitempty"`
 }
 
-func getConfigPath() string {
+func getConfigPath() (string, error) {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	return filepath.Join(homedir, folderName, configName)
+	return filepath.Join(homedir, folderName, configName), nil
 }
 
-func createConfig() {
-	if _, err := os.Stat(getConfigPath()); err == nil {
-		log.Default().Println("Config file found, skipping creation...")
-		return
-	}
-
-	homedir, err := os.UserHomeDir()
+func createConfig() error {
+	path, err := getConfigPath()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	pathToFolder := filepath.Join(homedir, folderName)
+	if _, err := os.Stat(path); err == nil {
+		log.Default().Println("Config file found, skipping creation...")
+		return nil
+	}
 
-	if _, err := os.Stat(pathToFolder); os.IsNotExist(err) {
-		err := os.Mkdir(pathToFolder, 0775)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
 	}
 
-	f, err := os.Create(getConfigPath())
+	f, err := os.Create(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	defer f.Close()
@@ -88,75 +142,98 @@ func createConfig() {
 		MaxTokens:     0,
 	}
 
-	data, err := yaml.Marshal(&config)
-	if err != nil {
-		log.Fatal(err)
+	if err := writeConfig(config); err != nil {
+		return err
 	}
 
-	err2 := ioutil.WriteFile(f.Name(), data, 0)
+	fmt.Println("Config file created at: ", f.Name())
 
-	if err2 != nil {
-		log.Fatal(err2)
+	return nil
+}
+
+func writeConfig(config Config) error {
+	path, err := getConfigPath()
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Config file created at: ", f.Name())
-}
+	data, err := yaml.Marshal(&config)
+	if err != nil {
+		return err
+	}
 
-func saveToConfig(key string, value string) {
-	path := getConfigPath()
+	return ioutil.WriteFile(path, data, 0)
+}
 
-	config := parseConfig()
+func saveToConfig(key string, value string) error {
+	config, err := parseConfig()
+	if err != nil {
+		return err
+	}
 
 	switch key {
 	case "temperature":
 		res, err := strconv.ParseFloat(value, 64)
 		if err != nil {
+			return fmt.Errorf("%w: temperature must be a number", ErrConfig)
 		}
 		config.Temperature = res
 	case "max_tokens":
 		res, err := strconv.Atoi(value)
 		if err != nil {
+			return fmt.Errorf("%w: max_tokens must be a whole number", ErrConfig)
 		}
 		config.MaxTokens = res
 	case "model":
 		config.Model = value
 	case "token":
 		config.Token = value
+	case "provider":
+		config.Provider = value
+	case "persona":
+		found := false
+		for i := range config.Personalities {
+			config.Personalities[i].Active = config.Personalities[i].Name == value
+			found = found || config.Personalities[i].Active
+		}
+		if !found {
+			return fmt.Errorf("%w: there is no personality named %q", ErrConfig, value)
+		}
 	}
 
-	data, err := yaml.Marshal(&config)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err2 := ioutil.WriteFile(path, data, 0)
-
-	if err2 != nil {
-		log.Fatal(err2)
+	if err := writeConfig(config); err != nil {
+		return err
 	}
 
+	path, _ := getConfigPath()
 	fmt.Println(key+" saved to config file at: ", path)
+
+	return nil
 }
 
-func parseConfig() Config {
-	path := getConfigPath()
+func parseConfig() (Config, error) {
+	var config Config
+
+	path, err := getConfigPath()
+	if err != nil {
+		return config, err
+	}
 
 	if _, err := os.Stat(path); err != nil {
-		log.Fatal("Config file not found, please run `cligpt init` first")
+		return config, ErrNoConfig
 	}
 
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		return config, err
 	}
 
-	var config Config
 	err = yaml.Unmarshal(data, &config)
 	if err != nil {
-		log.Fatal(err)
+		return config, fmt.Errorf("%w: %v", ErrConfig, err)
 	}
 
-	return config
+	return config, nil
 }
 
 func getDefaultPersonalities() []Personality {
@@ -173,29 +250,79 @@ func getDefaultPersonalities() []Personality {
 	return personalities
 }
 
-func SelectAndSaveModel() {
+// SelectAndSaveModel saves the picked model with its provider, and asks for a
+// token when the provider changes since the old one's token won't work.
+func SelectAndSaveModel() error {
+	changed, err := selectModel()
+	if err != nil || !changed {
+		return err
+	}
+
+	return GetAndSaveToken()
+}
+
+// selectModel saves the picked model and its provider, it reports whether
+// the provider changed.
+func selectModel() (bool, error) {
 	selectModelPromptContent := promptSelectContent{
 		label:        "Select a model",
-		selectValues: []string{"chatgpt", "gpt4"},
+		selectValues: []string{"chatgpt", "gpt4", "claude"},
+	}
+	promptResult, err := promptGetSelect(selectModelPromptContent)
+	if err != nil {
+		return false, err
+	}
+
+	if err := saveToConfig("model", models[promptResult.value]); err != nil {
+		return false, err
+	}
+
+	// The model only works with its own provider
+	config, err := parseConfig()
+	if err != nil {
+		return false, err
+	}
+	provider := modelProviders[promptResult.value]
+	if config.Provider == provider || (config.Provider == "" && provider == "openai") {
+		return false, nil
 	}
-	promptResult := promptGetSelect(selectModelPromptContent)
-	saveToConfig("model", models[promptResult.value])
+
+	return true, saveToConfig("provider", provider)
 }
 
-func GetAndSaveToken() {
+// GetAndSaveToken asks for the API token of the configured provider.
+func GetAndSaveToken() error {
+	config, err := parseConfig()
+	if err != nil {
+		return err
+	}
+	provider := config.Provider
+	if provider == "" {
+		provider = "openai"
+	}
+	name, ok := providerNames[provider]
+	if !ok {
+		name = provider
+	}
+
 	getTokenInputContent := promptInputContent{
 		errorMsg: "Please enter a valid token",
-		label:    "Enter your OpenAI token:",
+		label:    fmt.Sprintf("Enter your %s token:", name),
+		// Keys of other servers, like local ones, have all kinds of formats
 		isValidInputString: func(input string) bool {
-			return strings.HasPrefix(input, "sk-")
+			return input != "" && !strings.ContainsAny(input, " \t")
 		},
 	}
 
-	token := promptGetInput(getTokenInputContent)
-	saveToConfig("token", token)
+	token, err := promptGetInput(getTokenInputContent)
+	if err != nil {
+		return err
+	}
+
+	return saveToConfig("token", token)
 }
 
-func AddPersonality() {
+func AddPersonality() error {
 	getPersonalityNameInputContent := promptInputContent{
 		errorMsg: "Please enter a valid name",
 		label:    "Enter a name for the personality:",
@@ -204,7 +331,10 @@ func AddPersonality() {
 		},
 	}
 
-	name := promptGetInput(getPersonalityNameInputContent)
+	name, err := promptGetInput(getPersonalityNameInputContent)
+	if err != nil {
+		return err
+	}
 
 	getPersonalityContextInputContent := promptInputContent{
 		errorMsg: "Please enter a valid context",
@@ -214,9 +344,15 @@ func AddPersonality() {
 		},
 	}
 
-	context := promptGetInput(getPersonalityContextInputContent)
+	context, err := promptGetInput(getPersonalityContextInputContent)
+	if err != nil {
+		return err
+	}
 
-	config := parseConfig()
+	config, err := parseConfig()
+	if err != nil {
+		return err
+	}
 
 	for persona := range config.Personalities {
 		config.Personalities[persona].Active = false // Deactivate all old personalities
@@ -224,25 +360,24 @@ func AddPersonality() {
 
 	config.Personalities = append(config.Personalities, Personality{Name: name, Context: context, Active: true})
 
-	data, err := yaml.Marshal(&config)
-	if err != nil {
-		log.Fatal(err)
+	if err := writeConfig(config); err != nil {
+		return err
 	}
 
-	err2 := ioutil.WriteFile(getConfigPath(), data, 0)
-
-	if err2 != nil {
-		log.Fatal(err2)
-	}
+	path, _ := getConfigPath()
+	fmt.Println("Personality saved to config file at: ", path)
 
-	fmt.Println("Personality saved to config file at: ", getConfigPath())
+	return nil
 }
 
-func SetActivePersonality() {
-	config := parseConfig()
+func SetActivePersonality() error {
+	config, err := parseConfig()
+	if err != nil {
+		return err
+	}
 
 	if len(config.Personalities) == 0 {
-		log.Fatal("No personalities found, please add one first")
+		return fmt.Errorf("%w: no personalities found, please add one first", ErrConfig)
 	}
 
 	var personalityNames []string
@@ -254,7 +389,10 @@ func SetActivePersonality() {
 		label:        "Select a personality",
 		selectValues: personalityNames,
 	}
-	promptResult := promptGetSelect(selectPersonalityPromptContent)
+	promptResult, err := promptGetSelect(selectPersonalityPromptContent)
+	if err != nil {
+		return err
+	}
 
 	var selected string
 	for i := range config.Personalities {
@@ -266,23 +404,18 @@ func SetActivePersonality() {
 		}
 	}
 
-	data, err := yaml.Marshal(&config)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err2 := ioutil.WriteFile(getConfigPath(), data, 0)
-
-	if err2 != nil {
-		log.Fatal(err2)
+	if err := writeConfig(config); err != nil {
+		return err
 	}
 
 	fmt.Print("Selected personality: ")
 	printResponse(selected)
 	fmt.Println()
+
+	return nil
 }
 
-func SetTemperature() {
+func SetTemperature() error {
 	getTemperatureInputContent := promptInputContent{
 		errorMsg: "Please enter a valid temperature",
 		label:    "Enter a temperature:",
@@ -295,11 +428,15 @@ func SetTemperature() {
 		},
 	}
 
-	temperature := promptGetInput(getTemperatureInputContent)
-	saveToConfig("temperature", temperature)
+	temperature, err := promptGetInput(getTemperatureInputContent)
+	if err != nil {
+		return err
+	}
+
+	return saveToConfig("temperature", temperature)
 }
 
-func SetMaxTokens() {
+func SetMaxTokens() error {
 	getMaxTokensInputContent := promptInputContent{
 		errorMsg: "Please enter a valid max tokens",
 		label:    "Enter a max tokens:",
@@ -312,6 +449,10 @@ func SetMaxTokens() {
 		},
 	}
 
-	maxTokens := promptGetInput(getMaxTokensInputContent)
-	saveToConfig("max_tokens", maxTokens)
+	maxTokens, err := promptGetInput(getMaxTokensInputContent)
+	if err != nil {
+		return err
+	}
+
+	return saveToConfig("max_tokens", maxTokens)
 }
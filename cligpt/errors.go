@@ -0,0 +1,159 @@
+package cligpt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by the package are wrapped around one of these, so callers
+// can tell what went wrong with errors.Is.
+var (
+	ErrNoConfig    = errors.New("config file not found, please run `cligpt init` first")
+	ErrConfig      = errors.New("invalid config")
+	ErrAuth        = errors.New("authentication failed")
+	ErrRateLimited = errors.New("rate limited")
+	ErrNetwork     = errors.New("network error")
+	ErrAPI         = errors.New("API error")
+	// ErrContextLength is returned when the conversation doesn't fit in the
+	// context window of the model
+	ErrContextLength = errors.New("context length exceeded")
+	ErrUnsupported   = errors.New("not supported by the configured provider")
+	ErrBudget        = errors.New("budget exceeded")
+	ErrAborted       = errors.New("aborted")
+)
+
+// Short descriptions of the error codes and types users run into most, keyed
+// by the OpenAI error code or the Anthropic error type.
+var apiErrorSummaries = map[string]string{
+	"invalid_api_key":         "invalid API key",
+	"authentication_error":    "invalid API key",
+	"permission_error":        "API key not allowed to use this resource",
+	"context_length_exceeded": "context length exceeded",
+	"model_not_found":         "model not found",
+	"not_found_error":         "not found",
+	"insufficient_quota":      "quota exceeded, check your plan and billing details",
+	"rate_limit_exceeded":     "rate limit exceeded",
+	"rate_limit_error":        "rate limit exceeded",
+	"overloaded_error":        "the API is overloaded",
+}
+
+// APIError is an error response from a provider. It is parsed from the
+// {"error": {"type", "code", "message"}} envelope that both OpenAI and
+// Anthropic use.
+type APIError struct {
+	Status    int
+	Type      string
+	Code      string
+	Message   string
+	RequestID string
+}
+
+type apiErrorBody struct {
+	Type    string      `json:"type"`
+	Code    interface{} `json:"code"`
+	Message string      `json:"message"`
+}
+
+type apiErrorEnvelope struct {
+	Error *apiErrorBody `json:"error"`
+}
+
+func (e *APIError) Error() string {
+	var details []string
+	if e.Status != 0 {
+		details = append(details, fmt.Sprintf("status %d", e.Status))
+	}
+	if e.RequestID != "" {
+		details = append(details, "request "+e.RequestID)
+	}
+
+	message := e.Message
+	if summary := e.summary(); summary != "" {
+		message = summary + ": " + message
+	}
+	if len(details) > 0 {
+		message += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	return message
+}
+
+func (e *APIError) summary() string {
+	if summary, ok := apiErrorSummaries[e.Code]; ok {
+		return summary
+	}
+
+	return apiErrorSummaries[e.Type]
+}
+
+// Unwrap lets errors.Is match an APIError against ErrAuth, ErrRateLimited or
+// ErrAPI.
+func (e *APIError) Unwrap() error {
+	switch e.Status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAuth
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+
+	// Errors sent inside a stream have no status
+	switch {
+	case e.Type == "authentication_error", e.Code == "invalid_api_key":
+		return ErrAuth
+	case e.Type == "rate_limit_error", e.Code == "rate_limit_exceeded":
+		return ErrRateLimited
+	case e.Code == "context_length_exceeded":
+		return ErrContextLength
+	}
+
+	return ErrAPI
+}
+
+func newAPIError(body *apiErrorBody) *APIError {
+	apiErr := &APIError{Type: body.Type, Message: body.Message}
+
+	// OpenAI sends the code as a string, some compatible servers as a number
+	if body.Code != nil {
+		apiErr.Code = fmt.Sprint(body.Code)
+	}
+
+	return apiErr
+}
+
+// checkResponse returns an *APIError for any non 2xx response.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: error reading response body: %v", ErrNetwork, err)
+	}
+
+	apiErr := &APIError{}
+
+	var envelope apiErrorEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error != nil {
+		apiErr = newAPIError(envelope.Error)
+	}
+
+	if apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	apiErr.Status = resp.StatusCode
+	apiErr.RequestID = resp.Header.Get("x-request-id")
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("request-id")
+	}
+
+	return apiErr
+}
@@ -0,0 +1,138 @@
+package cligpt
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		header  http.Header
+		body    string
+		err     error
+		message string
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"choices":[]}`,
+		},
+		{
+			name:    "invalid key",
+			status:  http.StatusUnauthorized,
+			header:  http.Header{"X-Request-Id": {"req_123"}},
+			body:    `{"error":{"type":"invalid_request_error","code":"invalid_api_key","message":"Incorrect API key provided"}}`,
+			err:     ErrAuth,
+			message: "invalid API key: Incorrect API key provided (status 401, request req_123)",
+		},
+		{
+			name:    "forbidden",
+			status:  http.StatusForbidden,
+			body:    `{"type":"error","error":{"type":"permission_error","message":"not allowed"}}`,
+			err:     ErrAuth,
+			message: "API key not allowed to use this resource: not allowed (status 403)",
+		},
+		{
+			name:    "rate limited",
+			status:  http.StatusTooManyRequests,
+			header:  http.Header{"Request-Id": {"req_456"}},
+			body:    `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+			err:     ErrRateLimited,
+			message: "rate limit exceeded: slow down (status 429, request req_456)",
+		},
+		{
+			name:    "context length",
+			status:  http.StatusBadRequest,
+			body:    `{"error":{"type":"invalid_request_error","code":"context_length_exceeded","message":"This model's maximum context length is 8192 tokens"}}`,
+			err:     ErrContextLength,
+			message: "context length exceeded: This model's maximum context length is 8192 tokens (status 400)",
+		},
+		{
+			name:    "numeric code",
+			status:  http.StatusBadRequest,
+			body:    `{"error":{"code":400,"message":"bad request"}}`,
+			err:     ErrAPI,
+			message: "bad request (status 400)",
+		},
+		{
+			name:    "server error without a body",
+			status:  http.StatusInternalServerError,
+			err:     ErrAPI,
+			message: "Internal Server Error (status 500)",
+		},
+		{
+			name:    "bad gateway page",
+			status:  http.StatusBadGateway,
+			body:    "<html>502 Bad Gateway</html>\n",
+			err:     ErrAPI,
+			message: "<html>502 Bad Gateway</html> (status 502)",
+		},
+		{
+			name:    "unknown code",
+			status:  http.StatusNotFound,
+			body:    `{"error":{"type":"invalid_request_error","code":"something_new","message":"what is this"}}`,
+			err:     ErrAPI,
+			message: "what is this (status 404)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: test.status, Header: test.header, Body: io.NopCloser(strings.NewReader(test.body))}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+
+			err := checkResponse(resp)
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *APIError", err)
+			}
+			if apiErr.Status != test.status {
+				t.Errorf("status = %d, want %d", apiErr.Status, test.status)
+			}
+			if !errors.Is(err, test.err) {
+				t.Errorf("error = %v, want %v", err, test.err)
+			}
+			if err.Error() != test.message {
+				t.Errorf("message = %q, want %q", err.Error(), test.message)
+			}
+		})
+	}
+}
+
+// Errors sent inside a stream have no status, they are told apart by type
+// and code.
+func TestAPIErrorUnwrapInStream(t *testing.T) {
+	tests := []struct {
+		body apiErrorBody
+		err  error
+	}{
+		{apiErrorBody{Type: "authentication_error"}, ErrAuth},
+		{apiErrorBody{Code: "invalid_api_key"}, ErrAuth},
+		{apiErrorBody{Type: "rate_limit_error"}, ErrRateLimited},
+		{apiErrorBody{Code: "rate_limit_exceeded"}, ErrRateLimited},
+		{apiErrorBody{Code: "context_length_exceeded"}, ErrContextLength},
+		{apiErrorBody{Type: "overloaded_error"}, ErrAPI},
+		{apiErrorBody{Type: "server_error", Code: 500.0}, ErrAPI},
+	}
+
+	for _, test := range tests {
+		err := newAPIError(&test.body)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%+v: error = %v, want %v", test.body, err, test.err)
+		}
+	}
+}
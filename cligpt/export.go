@@ -0,0 +1,383 @@
+package cligpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// File extensions of the export formats
+var exportFormats = map[string]string{
+	"md":    ".md",
+	"json":  ".json",
+	"html":  ".html",
+	"jsonl": ".jsonl",
+}
+
+// ExportOptions select the sessions to export and where to. Without Out the
+// sessions are written to stdout, with it every session gets its own file.
+type ExportOptions struct {
+	IDs    []int
+	All    bool
+	Format string
+	Out    string
+}
+
+// ExportSessions writes sessions as Markdown or HTML transcripts, as JSON, or
+// as JSONL in the chat fine-tuning format.
+func ExportSessions(options ExportOptions) error {
+	ext, ok := exportFormats[options.Format]
+	if !ok {
+		return fmt.Errorf("invalid --format %q, use md, json, html or jsonl", options.Format)
+	}
+
+	ids := options.IDs
+	if options.All {
+		infos, _, err := db.ListSessions(-1, 0)
+		if err != nil {
+			return err
+		}
+		if len(infos) == 0 {
+			return db.ErrNoSessions
+		}
+		for _, info := range infos {
+			ids = append(ids, info.ID)
+		}
+		sort.Ints(ids)
+	}
+
+	// Read all of them first, so nothing is written if one doesn't exist
+	var sessions []types.Session
+	for _, id := range ids {
+		session, err := db.GetSession(id)
+		if err != nil {
+			return err
+		}
+		if options.Format == "jsonl" && !hasAnswer(session.Messages) {
+			fmt.Fprintf(os.Stderr, "Warning: session %d has no answer to train on, it was left out\n", id)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	if options.Out == "" {
+		return writeExport(os.Stdout, options.Format, sessions)
+	}
+
+	if err := os.MkdirAll(options.Out, 0775); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		path := filepath.Join(options.Out, fmt.Sprintf("session-%d%s", session.ID, ext))
+		if err := writeExportFile(path, options.Format, session); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Exported %d sessions to %s\n", len(sessions), options.Out)
+
+	return nil
+}
+
+func writeExportFile(path string, format string, session types.Session) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := writeExport(f, format, []types.Session{session}); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeExport(w io.Writer, format string, sessions []types.Session) error {
+	switch format {
+	case "md":
+		return exportMarkdown(w, sessions)
+	case "html":
+		return exportHTML(w, sessions)
+	case "json":
+		return exportJSON(w, sessions)
+	default:
+		return exportJSONL(w, sessions)
+	}
+}
+
+// transcript holds what the Markdown and HTML exports show of a session.
+type transcript struct {
+	ID       int
+	ParentID int
+	Title    string
+	Updated  string
+	// Persona is the content of the leading system messages, which are
+	// shown in the header instead of with the messages
+	Persona  string
+	Models   string
+	Messages []transcriptMessage
+}
+
+type transcriptMessage struct {
+	Role      string
+	Model     string
+	Time      string
+	Content   string
+	Files     []string
+	Images    []template.URL
+	Truncated bool
+}
+
+func newTranscript(session types.Session) transcript {
+	t := transcript{
+		ID:       session.ID,
+		ParentID: session.ParentID,
+		Title:    sessionName(session.Title, firstUserMessage(session.Messages)),
+		Updated:  session.UpdatedAt.Local().Format("2006-01-02 15:04"),
+	}
+	if t.Title == "" {
+		t.Title = fmt.Sprintf("Session %d", session.ID)
+	}
+
+	messages := session.Messages
+	var persona []string
+	for len(messages) > 0 && messages[0].Role == "system" {
+		persona = append(persona, messages[0].Content)
+		messages = messages[1:]
+	}
+	t.Persona = strings.Join(persona, "\n\n")
+
+	var models []string
+	seen := map[string]bool{}
+	for _, message := range messages {
+		if message.Model != "" && !seen[message.Model] {
+			seen[message.Model] = true
+			models = append(models, message.Model)
+		}
+
+		m := transcriptMessage{
+			Role:      message.Role,
+			Model:     message.Model,
+			Content:   message.Content,
+			Files:     message.Files,
+			Truncated: message.Truncated,
+		}
+		if !message.CreatedAt.IsZero() {
+			m.Time = message.CreatedAt.Local().Format("2006-01-02 15:04")
+		}
+		for _, part := range message.Parts {
+			if part.Type == "image_url" && part.ImageURL != nil {
+				m.Images = append(m.Images, imageSource(part.ImageURL.URL))
+			}
+		}
+		t.Messages = append(t.Messages, m)
+	}
+	t.Models = strings.Join(models, ", ")
+
+	return t
+}
+
+// imageSource lets images given as web or data URIs be shown in the HTML
+// export, html/template would drop data URIs otherwise.
+func imageSource(url string) template.URL {
+	if strings.HasPrefix(url, "data:image/") || strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		return template.URL(url)
+	}
+
+	return ""
+}
+
+func roleName(role string) string {
+	switch role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "system":
+		return "System"
+	}
+
+	return role
+}
+
+func exportMarkdown(w io.Writer, sessions []types.Session) error {
+	var b strings.Builder
+	for i, session := range sessions {
+		if i > 0 {
+			b.WriteString("\n---\n\n")
+		}
+
+		t := newTranscript(session)
+		fmt.Fprintf(&b, "# %s\n\n", t.Title)
+		if t.ParentID != 0 {
+			fmt.Fprintf(&b, "- **Session:** %d, forked from %d\n", t.ID, t.ParentID)
+		} else {
+			fmt.Fprintf(&b, "- **Session:** %d\n", t.ID)
+		}
+		if t.Models != "" {
+			fmt.Fprintf(&b, "- **Models:** %s\n", t.Models)
+		}
+		fmt.Fprintf(&b, "- **Updated:** %s\n", t.Updated)
+		if t.Persona != "" {
+			b.WriteString("- **Persona:**\n\n")
+			for _, line := range strings.Split(t.Persona, "\n") {
+				b.WriteString(strings.TrimRight("  > "+line, " ") + "\n")
+			}
+		}
+
+		for _, m := range t.Messages {
+			heading := roleName(m.Role)
+			if m.Model != "" {
+				heading += " (" + m.Model + ")"
+			}
+			if m.Time != "" {
+				heading += " · " + m.Time
+			}
+			fmt.Fprintf(&b, "\n## %s\n\n", heading)
+
+			if len(m.Files) > 0 {
+				fmt.Fprintf(&b, "*Files: %s*\n\n", strings.Join(m.Files, ", "))
+			}
+			b.WriteString(strings.TrimRight(m.Content, "\n"))
+			b.WriteString(strings.Repeat(" [image]", len(m.Images)))
+			if m.Truncated {
+				b.WriteString("\n\n*[truncated]*")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+var htmlExport = template.Must(template.New("export").Funcs(template.FuncMap{"roleName": roleName}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{if eq (len .) 1}}{{(index . 0).Title}}{{else}}Chat sessions{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+header dl { display: grid; grid-template-columns: max-content auto; gap: 0.25rem 1rem; color: #555; }
+header dd { margin: 0; white-space: pre-wrap; }
+.message { margin: 1rem 0; padding: 0.75rem 1rem; border-radius: 0.5rem; }
+.user { background: #eef3fb; }
+.assistant { background: #f1f8ef; }
+.system { background: #f6f6f6; }
+.meta { font-size: 0.85rem; color: #666; margin-bottom: 0.5rem; }
+.content { white-space: pre-wrap; font-family: ui-monospace, monospace; font-size: 0.9rem; }
+.message img { max-width: 100%; margin-top: 0.5rem; }
+hr { margin: 3rem 0; }
+</style>
+</head>
+<body>
+{{range $i, $t := .}}{{if $i}}<hr>
+{{end}}<article>
+<header>
+<h1>{{$t.Title}}</h1>
+<dl>
+<dt>Session</dt><dd>{{$t.ID}}{{if $t.ParentID}}, forked from {{$t.ParentID}}{{end}}</dd>
+{{if $t.Models}}<dt>Models</dt><dd>{{$t.Models}}</dd>
+{{end}}<dt>Updated</dt><dd>{{$t.Updated}}</dd>
+{{if $t.Persona}}<dt>Persona</dt><dd>{{$t.Persona}}</dd>
+{{end}}</dl>
+</header>
+{{range $t.Messages}}<section class="message {{.Role}}">
+<div class="meta"><strong>{{roleName .Role}}</strong>{{if .Model}} ({{.Model}}){{end}}{{if .Time}} · {{.Time}}{{end}}{{if .Files}} · Files: {{range $j, $f := .Files}}{{if $j}}, {{end}}{{$f}}{{end}}{{end}}</div>
+<div class="content">{{.Content}}{{if .Truncated}} [truncated]{{end}}</div>
+{{range .Images}}{{if .}}<img src="{{.}}" alt="image">
+{{end}}{{end}}</section>
+{{end}}</article>
+{{end}}</body>
+</html>
+`))
+
+func exportHTML(w io.Writer, sessions []types.Session) error {
+	transcripts := make([]transcript, len(sessions))
+	for i, session := range sessions {
+		transcripts[i] = newTranscript(session)
+	}
+
+	return htmlExport.Execute(w, transcripts)
+}
+
+// exportJSON writes a single session as an object and several as an array,
+// in the format of sessions show --json.
+func exportJSON(w io.Writer, sessions []types.Session) error {
+	var value interface{} = sessions
+	if len(sessions) == 1 {
+		value = sessions[0]
+	} else if sessions == nil {
+		value = []types.Session{}
+	}
+
+	output, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(output))
+
+	return err
+}
+
+// fineTuningMessage is a message of the chat fine-tuning format. Weight 0
+// keeps an answer out of the training, it is set for interrupted answers.
+type fineTuningMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+	Weight  *int        `json:"weight,omitempty"`
+}
+
+func hasAnswer(messages []types.Message) bool {
+	for _, message := range messages {
+		if message.Role == "assistant" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportJSONL writes every session as a line of {"messages":[...]}. Messages
+// after the last answer are left out, the examples have to end with one.
+func exportJSONL(w io.Writer, sessions []types.Session) error {
+	for _, session := range sessions {
+		last := len(session.Messages) - 1
+		for last >= 0 && session.Messages[last].Role != "assistant" {
+			last--
+		}
+
+		example := struct {
+			Messages []fineTuningMessage `json:"messages"`
+		}{}
+		for _, message := range session.Messages[:last+1] {
+			m := fineTuningMessage{Role: message.Role, Content: message.Content}
+			if len(message.Parts) > 0 {
+				m.Content = message.Parts
+			}
+			if message.Role == "assistant" && message.Truncated {
+				m.Weight = new(int)
+			}
+			example.Messages = append(example.Messages, m)
+		}
+
+		line, err := json.Marshal(example)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, string(line)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
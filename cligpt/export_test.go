@@ -0,0 +1,79 @@
+package cligpt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// fixtureConversations reads the ChatGPT export in testdata.
+func fixtureConversations(t *testing.T) []importedConversation {
+	t.Helper()
+
+	data, err := ioutil.ReadFile("testdata/conversations.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversations, err := parseImport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conversations
+}
+
+func TestExportJSONL(t *testing.T) {
+	var sessions []types.Session
+	for _, conversation := range fixtureConversations(t) {
+		// As ExportSessions does, the conversation that was never started
+		// has nothing to train on
+		if session := conversation.session(); hasAnswer(session.Messages) {
+			sessions = append(sessions, session)
+		}
+	}
+	sessions = append(sessions, types.Session{Messages: []types.Message{
+		createMessage("system", "be brief"),
+		{Role: "user", Content: "what is this?", Parts: []types.ContentPart{
+			{Type: "text", Text: "what is this?"},
+			{Type: "image_url", ImageURL: &types.ImageURL{URL: "data:image/png;base64,iVBORw0KGgo="}},
+		}},
+		{Role: "assistant", Content: "A cat sitting on", Truncated: true},
+		createMessage("user", "go on"),
+		createMessage("assistant", "a keyboard."),
+		// Not answered yet
+		createMessage("user", "and then?"),
+	}})
+
+	var out bytes.Buffer
+	if err := exportJSONL(&out, sessions); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"messages":[{"role":"user","content":"[image]\nWhy does this goroutine leak?"},{"role":"assistant","content":"The channel is never closed."},{"role":"user","content":"Thanks!"},{"role":"assistant","content":"You're welcome."}]}`,
+		`{"messages":[{"role":"user","content":"What is 2+2?"},{"role":"assistant","content":"4"}]}`,
+		`{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}}]},{"role":"assistant","content":"A cat sitting on","weight":0},{"role":"user","content":"go on"},{"role":"assistant","content":"a keyboard."}]}`,
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("%d lines, want %d:\n%s", len(lines), len(want), out.String())
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d:\n%s\nwant\n%s", i+1, lines[i], want[i])
+		}
+	}
+}
+
+func TestExportJSONLEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := exportJSONL(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q, want nothing", out.String())
+	}
+}
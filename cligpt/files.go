@@ -0,0 +1,376 @@
+package cligpt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+const (
+	// Files above this size are cut off
+	maxFileBytes = 100 * 1024
+	// Files are skipped once the attachments of a message reach this size
+	maxAttachmentBytes = 500 * 1024
+	// Bytes checked for a NUL byte to tell binary files apart, same as git
+	binarySniffBytes = 8000
+	// Largest image accepted by the OpenAI API
+	maxImageBytes = 20 * 1024 * 1024
+)
+
+// Image formats accepted by both OpenAI and Anthropic
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// expandFilePatterns resolves the paths and globs given with --file or /add.
+// Globs support ** to match any number of directories.
+func expandFilePatterns(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+
+	for _, pattern := range patterns {
+		var matches []string
+
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", pattern)
+			}
+		} else {
+			info, err := os.Stat(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				return nil, fmt.Errorf("%s is a directory, use a glob like %s", pattern, filepath.Join(pattern, "**", "*"))
+			}
+			matches = []string{pattern}
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// glob returns the files matching the pattern. Only a pattern with ** walks
+// the directory it starts in, directories that can't hold a match and
+// directories that can't be read are skipped.
+func glob(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+	segments := strings.Split(pattern, string(filepath.Separator))
+
+	if !containsSegment(segments, "**") {
+		return globFiles(pattern)
+	}
+
+	// Walk from the longest leading path without any glob characters
+	root := 0
+	for root < len(segments)-1 && !strings.ContainsAny(segments[root], "*?[") {
+		root++
+	}
+	base := strings.Join(segments[:root], string(filepath.Separator))
+	if base == "" && filepath.IsAbs(pattern) {
+		base = string(filepath.Separator)
+	}
+
+	walkRoot := base
+	if walkRoot == "" {
+		walkRoot = "."
+	}
+
+	var matches []string
+	err := filepath.WalkDir(walkRoot, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrPermission) && path != walkRoot {
+			fmt.Fprintln(os.Stderr, "Warning: skipping", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(walkRoot, path)
+		if err != nil {
+			return err
+		}
+		relSegments := strings.Split(rel, string(filepath.Separator))
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if rel != "." && !mayHoldMatches(segments[root:], relSegments) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ok, err := matchSegments(segments[root:], relSegments)
+		if err != nil {
+			return err
+		}
+		if ok {
+			matches = append(matches, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// globFiles returns the files matching a pattern without **, directories
+// matching it are left out.
+func globFiles(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			matches = append(matches, path)
+		}
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+func containsSegment(segments []string, segment string) bool {
+	for _, s := range segments {
+		if s == segment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mayHoldMatches reports whether files below the directory dir may match the
+// pattern. Any directory can hold a match once a ** is reached.
+func mayHoldMatches(pattern []string, dir []string) bool {
+	for i, name := range dir {
+		if i >= len(pattern)-1 {
+			// The last segment of the pattern names files
+			return false
+		}
+		if pattern[i] == "**" {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern[i], name); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchSegments(pattern []string, path []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(path) == 0, nil
+	}
+
+	if pattern[0] == "**" {
+		// ** matches zero or more directories
+		for i := 0; i <= len(path); i++ {
+			if ok, err := matchSegments(pattern[1:], path[i:]); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	if len(path) == 0 {
+		return false, nil
+	}
+
+	ok, err := filepath.Match(pattern[0], path[0])
+	if !ok || err != nil {
+		return false, err
+	}
+
+	return matchSegments(pattern[1:], path[1:])
+}
+
+// readAttachment reads a text file, cutting it off at maxFileBytes. Binary
+// files return an error.
+func readAttachment(path string) (content string, truncated bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxFileBytes+1))
+	if err != nil {
+		return "", false, err
+	}
+
+	sniff := data
+	if len(sniff) > binarySniffBytes {
+		sniff = sniff[:binarySniffBytes]
+	}
+	if bytes.IndexByte(sniff, 0) != -1 {
+		return "", false, fmt.Errorf("%s is a binary file", path)
+	}
+
+	if len(data) > maxFileBytes {
+		data = data[:maxFileBytes]
+		truncated = true
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+			if r, size := utf8.DecodeLastRune(data); r != utf8.RuneError || size > 1 {
+				break
+			}
+			data = data[:len(data)-1]
+		}
+	}
+
+	if !utf8.Valid(data) {
+		return "", false, fmt.Errorf("%s is not a UTF-8 text file", path)
+	}
+
+	return string(data), truncated, nil
+}
+
+// formatAttachment puts the file in a fenced code block under a path header.
+func formatAttachment(path string, content string, truncated bool) string {
+	// The fence has to be longer than any run of backticks in the file
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+
+	header := "File: " + path
+	if truncated {
+		header += fmt.Sprintf(" (cut off at %d KB)", maxFileBytes/1024)
+	}
+
+	language := strings.TrimPrefix(filepath.Ext(path), ".")
+
+	return header + "\n" + fence + language + "\n" + strings.TrimRight(content, "\n") + "\n" + fence
+}
+
+// attachFiles reads the files matching the patterns and returns them
+// formatted for a message, along with the paths that were attached. Files
+// that can't be attached are skipped with a warning.
+func attachFiles(patterns []string) (string, []string, error) {
+	paths, err := expandFilePatterns(patterns)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var blocks []string
+	var attached []string
+	total := 0
+
+	for _, path := range paths {
+		content, truncated, err := readAttachment(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: skipping", err)
+			continue
+		}
+		if truncated {
+			fmt.Fprintf(os.Stderr, "Warning: %s is larger than %d KB, the rest was cut off\n", path, maxFileBytes/1024)
+		}
+
+		if total+len(content) > maxAttachmentBytes {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s, the attachments are over %d KB\n", path, maxAttachmentBytes/1024)
+			continue
+		}
+		total += len(content)
+
+		blocks = append(blocks, formatAttachment(path, content, truncated))
+		attached = append(attached, path)
+	}
+
+	return strings.Join(blocks, "\n\n"), attached, nil
+}
+
+// readImage reads an image into an image part holding it as a base64 data URI.
+func readImage(path string) (types.ContentPart, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return types.ContentPart{}, err
+	}
+	if info.Size() > maxImageBytes {
+		return types.ContentPart{}, fmt.Errorf("%s is larger than %d MB", path, maxImageBytes/1024/1024)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return types.ContentPart{}, err
+	}
+
+	mediaType := http.DetectContentType(data)
+	if !imageTypes[mediaType] {
+		return types.ContentPart{}, fmt.Errorf("%s is not a PNG, JPEG, GIF or WebP image", path)
+	}
+
+	url := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+
+	return types.ContentPart{Type: "image_url", ImageURL: &types.ImageURL{URL: url}}, nil
+}
+
+// newUserMessage creates a user message with the files matching the patterns
+// attached after the text. Images turn it into a multi-part message.
+func newUserMessage(text string, patterns []string, images []string) (types.Message, error) {
+	message := createMessage("user", text)
+
+	if len(patterns) > 0 {
+		attachments, attached, err := attachFiles(patterns)
+		if err != nil {
+			return message, err
+		}
+
+		if attachments != "" {
+			message.Content = strings.TrimSpace(text + "\n\n" + attachments)
+			message.Files = attached
+		}
+	}
+
+	if len(images) > 0 {
+		message.Parts = []types.ContentPart{{Type: "text", Text: message.Content}}
+		for _, path := range images {
+			part, err := readImage(path)
+			if err != nil {
+				return message, err
+			}
+			message.Parts = append(message.Parts, part)
+			message.Files = append(message.Files, path)
+		}
+	}
+
+	return message, nil
+}
@@ -0,0 +1,235 @@
+package cligpt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files with the given contents below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.go":                "package main",
+		"README.md":              "# readme",
+		"cmd/root.go":            "package cmd",
+		"cmd/root_test.go":       "package cmd",
+		"internal/a/b/deep.go":   "package b",
+		"internal/a/notes.txt":   "notes",
+		".git/hooks/pre-push.go": "package hooks",
+		"dir.go/file.txt":        "a directory named like a Go file",
+	})
+
+	tests := []struct {
+		pattern string
+		matches []string
+	}{
+		{"*.go", []string{"main.go"}},
+		{"cmd/*.go", []string{"cmd/root.go", "cmd/root_test.go"}},
+		{"cmd/*_test.go", []string{"cmd/root_test.go"}},
+		{"*/*.go", []string{"cmd/root.go", "cmd/root_test.go"}},
+		{"**/*.go", []string{"cmd/root.go", "cmd/root_test.go", "internal/a/b/deep.go", "main.go"}},
+		{"internal/**/*", []string{"internal/a/b/deep.go", "internal/a/notes.txt"}},
+		{"internal/**/b/*.go", []string{"internal/a/b/deep.go"}},
+		{"cmd/**", []string{"cmd/root.go", "cmd/root_test.go"}},
+		{"*.rs", nil},
+		{"**/*.rs", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			matches, err := glob(filepath.Join(dir, filepath.FromSlash(test.pattern)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rel []string
+			for _, match := range matches {
+				path, err := filepath.Rel(dir, match)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rel = append(rel, filepath.ToSlash(path))
+			}
+			if !reflect.DeepEqual(rel, test.matches) {
+				t.Errorf("matches = %q, want %q", rel, test.matches)
+			}
+		})
+	}
+
+	if _, err := glob(filepath.Join(dir, "[")); err == nil {
+		t.Error("a malformed pattern was accepted")
+	}
+}
+
+func TestGlobUnreadableDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads any directory")
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a/one.go":    "package a",
+		"locked/x.go": "package locked",
+	})
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	matches, err := glob(filepath.Join(dir, "**", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || filepath.Base(matches[0]) != "one.go" {
+		t.Errorf("matches = %q, want only one.go", matches)
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"cmd/*.go", "cmd/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "a/b/c/main.go", true},
+		{"**/*.go", "a/b/c/main.rs", false},
+		{"a/**/b/*.go", "a/b/x.go", true},
+		{"a/**/b/*.go", "a/x/y/b/x.go", true},
+		{"a/**/b/*.go", "a/x/y/c/x.go", false},
+		{"**", "a/b", true},
+		{"**/**/*.go", "a/main.go", true},
+		{"[ab]/*.go", "b/main.go", true},
+		{"?.go", "ab.go", false},
+	}
+
+	for _, test := range tests {
+		match, err := matchSegments(strings.Split(test.pattern, "/"), strings.Split(test.path, "/"))
+		if err != nil {
+			t.Fatalf("%s: %v", test.pattern, err)
+		}
+		if match != test.match {
+			t.Errorf("%s matching %s = %v, want %v", test.pattern, test.path, match, test.match)
+		}
+	}
+
+	if _, err := matchSegments([]string{"["}, []string{"a"}); err == nil {
+		t.Error("a malformed pattern was accepted")
+	}
+}
+
+func TestMayHoldMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		holds   bool
+	}{
+		{"a/**/*.go", "a", true},
+		{"a/**/*.go", "a/b/c", true},
+		{"a/**/*.go", "b", false},
+		{"*/x/**/*.go", "cmd/x/y", true},
+		{"*/x/**/*.go", "cmd/y", false},
+		{"*/*.go", "cmd", true},
+		{"*/*.go", "cmd/sub", false},
+	}
+
+	for _, test := range tests {
+		holds := mayHoldMatches(strings.Split(test.pattern, "/"), strings.Split(test.dir, "/"))
+		if holds != test.holds {
+			t.Errorf("%s below %s = %v, want %v", test.pattern, test.dir, holds, test.holds)
+		}
+	}
+}
+
+func TestReadAttachment(t *testing.T) {
+	dir := t.TempDir()
+	// A 3 byte character across the cut
+	large := strings.Repeat("a", maxFileBytes-1) + "€" + "tail"
+	writeFiles(t, dir, map[string]string{
+		"small.txt":  "hello\n",
+		"large.txt":  large,
+		"binary.bin": "PK\x03\x04\x00\x00",
+		"latin1.txt": "caf\xe9",
+	})
+
+	tests := []struct {
+		name      string
+		content   string
+		truncated bool
+		err       bool
+	}{
+		{"small.txt", "hello\n", false, false},
+		{"large.txt", strings.Repeat("a", maxFileBytes-1), true, false},
+		{"binary.bin", "", false, true},
+		{"latin1.txt", "", false, true},
+		{"missing.txt", "", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, truncated, err := readAttachment(filepath.Join(dir, test.name))
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want an error: %v", err, test.err)
+			}
+			if content != test.content {
+				t.Errorf("content is %d bytes, want %d", len(content), len(test.content))
+			}
+			if truncated != test.truncated {
+				t.Errorf("truncated = %v, want %v", truncated, test.truncated)
+			}
+		})
+	}
+}
+
+func TestAttachFilesTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	// Each file is just under the cap of a single file, the sixth one goes
+	// over the total
+	files := map[string]string{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		files[name+".txt"] = strings.Repeat(name, maxFileBytes-100)
+	}
+	files["g.txt"] = "small enough"
+	writeFiles(t, dir, files)
+
+	content, attached, err := attachFiles([]string{filepath.Join(dir, "*.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, path := range attached {
+		names = append(names, filepath.Base(path))
+	}
+	want := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt", "g.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("attached %q, want %q", names, want)
+	}
+	if strings.Contains(content, "ffff") {
+		t.Error("the file over the total size was attached")
+	}
+	if !strings.Contains(content, "File: "+filepath.Join(dir, "g.txt")) {
+		t.Error("a small file after the total was reached was skipped")
+	}
+}
@@ -0,0 +1,183 @@
+package cligpt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// turnStart returns the index of the user message that starts turn n, turns
+// are counted from 1. The turn after the last one starts at len(messages).
+func turnStart(messages []types.Message, n int) (int, error) {
+	turn := 0
+	for i, message := range messages {
+		if message.Role != "user" {
+			continue
+		}
+		turn++
+		if turn == n {
+			return i, nil
+		}
+	}
+
+	if n == turn+1 {
+		return len(messages), nil
+	}
+
+	return 0, fmt.Errorf("there is no turn %d, the chat has %d", n, turn)
+}
+
+// turnEnd returns the number of messages up to the end of turn n.
+func turnEnd(messages []types.Message, n int) (int, error) {
+	if turns := countTurns(messages); n > turns {
+		return 0, fmt.Errorf("there is no turn %d, the chat has %d", n, turns)
+	}
+
+	return turnStart(messages, n+1)
+}
+
+func countTurns(messages []types.Message) int {
+	turns := 0
+	for _, message := range messages {
+		if message.Role == "user" {
+			turns++
+		}
+	}
+
+	return turns
+}
+
+func parseTurn(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid turn %q, turns are numbered from 1", arg)
+	}
+
+	return n, nil
+}
+
+// forkSession saves a new session that shares the first keep messages of the
+// session, and returns it with them. Kept messages that aren't saved yet, like
+// a system message added by /persona, are saved with the fork later on.
+func forkSession(session types.Session, keep int) (types.Session, error) {
+	if session.ID == 0 {
+		return types.Session{}, fmt.Errorf("there is nothing to fork before the first answer")
+	}
+
+	forkedAt := 0
+	for i := keep - 1; i >= 0 && forkedAt == 0; i-- {
+		forkedAt = session.Messages[i].ID
+	}
+
+	fork, err := db.CreateSession(session.ID, forkedAt, nil)
+	if err != nil {
+		return types.Session{}, err
+	}
+	fork.Messages = append([]types.Message{}, session.Messages[:keep]...)
+
+	// The summary still stands for the same messages
+	if session.Summary != "" && session.SummaryUpTo <= keep {
+		fork.Summary = session.Summary
+		fork.SummaryUpTo = session.SummaryUpTo
+		if err := db.SaveSummary(fork.ID, fork.Summary, fork.SummaryUpTo); err != nil {
+			return types.Session{}, err
+		}
+	}
+
+	return fork, nil
+}
+
+// forkCommand handles /fork [n], which continues the chat in a fork of the
+// session after turn n, by default after the last turn.
+func (app *appEnv) forkCommand(args string) error {
+	messages := app.currentSession.Messages
+	n := countTurns(messages)
+	if args != "" {
+		var err error
+		if n, err = parseTurn(args); err != nil {
+			return err
+		}
+	}
+
+	keep, err := turnEnd(messages, n)
+	if err != nil {
+		return err
+	}
+
+	parentID := app.currentSession.ID
+	fork, err := forkSession(app.currentSession, keep)
+	if err != nil {
+		return err
+	}
+	app.currentSession = fork
+
+	fmt.Printf("Forked session %d after turn %d, the chat goes on in session %d\n", parentID, n, fork.ID)
+
+	return nil
+}
+
+// editCommand handles /edit <n> [message]. It forks the session before turn n
+// and returns the message to send instead of the one of turn n, asking for it
+// when it isn't given.
+func (app *appEnv) editCommand(args string) (string, error) {
+	arg, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+	n, err := parseTurn(arg)
+	if err != nil {
+		return "", err
+	}
+
+	messages := app.currentSession.Messages
+	if _, err := turnEnd(messages, n); err != nil {
+		return "", err
+	}
+	start, _ := turnStart(messages, n)
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		fmt.Printf("Turn %d was:\n%s\nType the new message:\n", n, messages[start].Content)
+		var ok bool
+		if text, ok = app.getUserInput(); !ok || strings.TrimSpace(text) == "" {
+			return "", fmt.Errorf("edit cancelled")
+		}
+	}
+
+	parentID := app.currentSession.ID
+	fork, err := forkSession(app.currentSession, start)
+	if err != nil {
+		return "", err
+	}
+	app.currentSession = fork
+
+	fmt.Printf("Forked session %d before turn %d, the chat goes on in session %d\n", parentID, n, fork.ID)
+
+	return text, nil
+}
+
+// ForkSession saves a fork of a session that shares its turns up to turn at,
+// 0 for all of them.
+func ForkSession(id int, at int) error {
+	session, err := db.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	if at == 0 {
+		at = countTurns(session.Messages)
+	}
+	keep, err := turnEnd(session.Messages, at)
+	if err != nil {
+		return err
+	}
+
+	fork, err := forkSession(session, keep)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Forked session %d after turn %d into session %d, continue it with: cligpt sessions resume %d\n", id, at, fork.ID, fork.ID)
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package cligpt
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+func contents(messages []types.Message) []string {
+	var contents []string
+	for _, message := range messages {
+		contents = append(contents, message.Content)
+	}
+
+	return contents
+}
+
+// savedChat saves a chat of two turns and returns it.
+func savedChat(t *testing.T) types.Session {
+	t.Helper()
+
+	session, err := db.CreateSession(0, 0, []types.Message{
+		createMessage("system", "be brief"),
+		createMessage("user", "q1"),
+		createMessage("assistant", "a1"),
+		createMessage("user", "q2"),
+		createMessage("assistant", "a2"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+func TestForkSession(t *testing.T) {
+	useTempHome(t)
+
+	tests := []struct {
+		name     string
+		unsaved  []types.Message
+		keep     int
+		forkedAt int
+		shared   []string
+	}{
+		{
+			name:     "after the last turn",
+			keep:     5,
+			forkedAt: 4,
+			shared:   []string{"be brief", "q1", "a1", "q2", "a2"},
+		},
+		{
+			name:     "after the first turn",
+			keep:     3,
+			forkedAt: 2,
+			shared:   []string{"be brief", "q1", "a1"},
+		},
+		{
+			name:     "before the first turn",
+			keep:     1,
+			forkedAt: 0,
+			shared:   []string{"be brief"},
+		},
+		{
+			name:     "nothing kept",
+			keep:     0,
+			forkedAt: -1,
+		},
+		{
+			name:     "after an unsaved message",
+			unsaved:  []types.Message{createMessage("system", "be a pirate")},
+			keep:     6,
+			forkedAt: 4,
+			shared:   []string{"be brief", "q1", "a1", "q2", "a2"},
+		},
+		{
+			name:     "after several unsaved messages",
+			unsaved:  []types.Message{createMessage("system", "be a pirate"), createMessage("system", "speak French")},
+			keep:     7,
+			forkedAt: 4,
+			shared:   []string{"be brief", "q1", "a1", "q2", "a2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := savedChat(t)
+			session.Messages = append(session.Messages, test.unsaved...)
+
+			fork, err := forkSession(session, test.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// forkedAt is the index of the last shared message
+			want := 0
+			if test.forkedAt >= 0 {
+				want = session.Messages[test.forkedAt].ID
+			}
+			if fork.ForkedAt != want {
+				t.Errorf("forked at message %d, want %d", fork.ForkedAt, want)
+			}
+			if got := contents(fork.Messages); !reflect.DeepEqual(got, contents(session.Messages[:test.keep])) {
+				t.Errorf("fork messages = %q, want %q", got, contents(session.Messages[:test.keep]))
+			}
+
+			saved, err := db.GetSession(fork.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.ParentID != session.ID {
+				t.Errorf("parent = %d, want %d", saved.ParentID, session.ID)
+			}
+			if got := contents(saved.Messages); !reflect.DeepEqual(got, test.shared) {
+				t.Errorf("saved fork messages = %q, want %q", got, test.shared)
+			}
+		})
+	}
+
+	if _, err := forkSession(types.Session{Messages: []types.Message{createMessage("user", "hi")}}, 1); err == nil {
+		t.Error("an unsaved session was forked")
+	}
+}
+
+// The unsaved messages kept by /fork are saved with the fork, after the
+// history it shares.
+func TestForkCommandUnsavedMessage(t *testing.T) {
+	useTempHome(t)
+
+	app := &appEnv{model: "gpt-4", currentSession: savedChat(t)}
+	parentID := app.currentSession.ID
+	app.currentSession.Messages = append(app.currentSession.Messages, createMessage("system", "be a pirate"))
+
+	if err := app.forkCommand(""); err != nil {
+		t.Fatal(err)
+	}
+	app.currentSession.Messages = append(app.currentSession.Messages, createMessage("user", "q3"))
+	if err := app.saveSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	fork, err := db.GetSession(app.currentSession.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"be brief", "q1", "a1", "q2", "a2", "be a pirate", "q3"}
+	if got := contents(fork.Messages); !reflect.DeepEqual(got, want) {
+		t.Errorf("fork messages = %q, want %q", got, want)
+	}
+
+	parent, err := db.GetSession(parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(parent.Messages); len(got) != 5 {
+		t.Errorf("parent messages = %q, the fork shouldn't change them", got)
+	}
+}
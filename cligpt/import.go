@@ -0,0 +1,294 @@
+package cligpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// importedConversation holds the fields of the formats that can be imported:
+// our JSON export and {"messages":[...]} lines have messages, the ChatGPT
+// export has a tree of mapping nodes instead.
+type importedConversation struct {
+	Title     string          `json:"title"`
+	Messages  []types.Message `json:"messages"`
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+	CurrentNode string                 `json:"current_node"`
+	CreateTime  float64                `json:"create_time"`
+	UpdateTime  float64                `json:"update_time"`
+}
+
+// chatGPTNode is a node of the message tree of a ChatGPT conversation. Every
+// edit or regenerated answer starts a new branch, current_node is the last
+// message of the branch that was shown.
+type chatGPTNode struct {
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+	Message  *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string `json:"content_type"`
+		// Parts are strings, or objects for images and files
+		Parts []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	// Recipient is "all" unless the message is a call to a tool
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// ImportSessions saves the conversations of ChatGPT data exports, of our own
+// JSON export and of {"messages":[...]} JSONL files as sessions. A file named
+// "-" is read from stdin. Conversations that are already saved are skipped.
+func ImportSessions(paths []string) error {
+	hashes, err := db.SessionHashes()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		var data []byte
+		if path == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+
+		conversations, err := parseImport(data)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		imported, duplicates, empty := 0, 0, 0
+		for _, conversation := range conversations {
+			session := conversation.session()
+			if len(session.Messages) == 0 {
+				empty++
+				continue
+			}
+
+			hash := db.ContentHash(session.Messages)
+			if _, ok := hashes[hash]; ok {
+				duplicates++
+				continue
+			}
+
+			id, err := db.ImportSession(session, hash)
+			if err != nil {
+				return err
+			}
+			hashes[hash] = id
+			imported++
+		}
+
+		fmt.Printf("%s: imported %d of %d conversations", path, imported, len(conversations))
+		if duplicates > 0 {
+			fmt.Printf(", %d were already saved", duplicates)
+		}
+		if empty > 0 {
+			fmt.Printf(", %d had no messages", empty)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+// parseImport reads a JSON array or object of conversations, or JSONL with a
+// conversation per line.
+func parseImport(data []byte) ([]importedConversation, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("the file is empty")
+	}
+
+	if json.Valid(data) {
+		if data[0] == '[' {
+			var conversations []importedConversation
+			if err := json.Unmarshal(data, &conversations); err != nil {
+				return nil, err
+			}
+			return conversations, nil
+		}
+
+		var conversation importedConversation
+		if err := json.Unmarshal(data, &conversation); err != nil {
+			return nil, err
+		}
+		return []importedConversation{conversation}, nil
+	}
+
+	var conversations []importedConversation
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var conversation importedConversation
+		if err := json.Unmarshal(line, &conversation); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	return conversations, nil
+}
+
+// session turns the conversation into a session. Messages of other roles
+// than user, assistant and system, like tool calls, and empty messages are
+// left out.
+func (c importedConversation) session() types.Session {
+	session := types.Session{Title: strings.TrimSpace(c.Title), UpdatedAt: c.UpdatedAt}
+
+	messages := c.Messages
+	if c.Mapping != nil {
+		messages = c.chatGPTMessages()
+		session.UpdatedAt = unixTime(c.UpdateTime)
+	}
+
+	for _, message := range messages {
+		if message.Role != "user" && message.Role != "assistant" && message.Role != "system" {
+			continue
+		}
+		if strings.TrimSpace(message.Content) == "" && len(message.Parts) == 0 {
+			continue
+		}
+
+		session.Messages = append(session.Messages, types.Message{
+			Role:      message.Role,
+			Content:   message.Content,
+			Parts:     message.Parts,
+			Truncated: message.Truncated,
+			Files:     message.Files,
+			Model:     message.Model,
+			Tokens:    message.Tokens,
+			CreatedAt: message.CreatedAt,
+		})
+	}
+
+	if session.UpdatedAt.IsZero() && len(session.Messages) > 0 {
+		session.UpdatedAt = session.Messages[len(session.Messages)-1].CreatedAt
+	}
+	if session.UpdatedAt.IsZero() {
+		session.UpdatedAt = unixTime(c.CreateTime)
+	}
+
+	return session
+}
+
+// chatGPTMessages follows the branch of the tree that ends at the current
+// node, or at the newest message when there is none, from its root.
+func (c importedConversation) chatGPTMessages() []types.Message {
+	current := c.CurrentNode
+	if _, ok := c.Mapping[current]; !ok {
+		current = c.newestLeaf()
+	}
+
+	var branch []chatGPTNode
+	// The length guards against a broken tree with a cycle
+	for id := current; id != "" && len(branch) <= len(c.Mapping); {
+		node, ok := c.Mapping[id]
+		if !ok {
+			break
+		}
+		branch = append(branch, node)
+		id = node.Parent
+	}
+
+	var messages []types.Message
+	for i := len(branch) - 1; i >= 0; i-- {
+		m := branch[i].Message
+		if m == nil || m.Metadata.Hidden || (m.Recipient != "" && m.Recipient != "all") {
+			continue
+		}
+		if m.Content.ContentType != "text" && m.Content.ContentType != "multimodal_text" {
+			continue
+		}
+
+		var texts []string
+		for _, part := range m.Content.Parts {
+			var text string
+			if err := json.Unmarshal(part, &text); err != nil {
+				// Images and files aren't part of the export
+				texts = append(texts, "[image]")
+				continue
+			}
+			if text != "" {
+				texts = append(texts, text)
+			}
+		}
+
+		messages = append(messages, types.Message{
+			Role:      m.Author.Role,
+			Content:   strings.Join(texts, "\n"),
+			Model:     m.Metadata.ModelSlug,
+			CreatedAt: unixTime(m.CreateTime),
+		})
+	}
+
+	return messages
+}
+
+func (c importedConversation) newestLeaf() string {
+	var leaves []string
+	for id, node := range c.Mapping {
+		if len(node.Children) == 0 {
+			leaves = append(leaves, id)
+		}
+	}
+
+	createTime := func(id string) float64 {
+		if m := c.Mapping[id].Message; m != nil {
+			return m.CreateTime
+		}
+		return 0
+	}
+	// Ties are broken by ID, so the same export always gives the same branch
+	sort.Slice(leaves, func(i, j int) bool {
+		if createTime(leaves[i]) != createTime(leaves[j]) {
+			return createTime(leaves[i]) > createTime(leaves[j])
+		}
+		return leaves[i] < leaves[j]
+	})
+
+	if len(leaves) == 0 {
+		return ""
+	}
+
+	return leaves[0]
+}
+
+// unixTime converts the seconds since the epoch of the ChatGPT export, 0 is
+// no time.
+func unixTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+
+	whole, fraction := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(fraction*1e9))
+}
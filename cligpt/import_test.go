@@ -0,0 +1,223 @@
+package cligpt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eitamonya/cligpt/db"
+)
+
+func TestParseImport(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		titles []string
+		err    bool
+	}{
+		{
+			name:   "array",
+			data:   `[{"title":"one","messages":[]},{"title":"two"}]`,
+			titles: []string{"one", "two"},
+		},
+		{
+			name:   "single object",
+			data:   "\n  {\"title\":\"one\",\"messages\":[{\"role\":\"user\",\"content\":\"hi\"}]}\n",
+			titles: []string{"one"},
+		},
+		{
+			name:   "JSONL",
+			data:   "{\"messages\":[{\"role\":\"user\",\"content\":\"hi\"}]}\r\n\n{\"title\":\"two\",\"messages\":[]}\n",
+			titles: []string{"", "two"},
+		},
+		{
+			name: "empty",
+			data: " \n",
+			err:  true,
+		},
+		{
+			name: "broken line",
+			data: "{\"title\":\"one\"}\n{\"title\":\n",
+			err:  true,
+		},
+		{
+			name: "not conversations",
+			data: `"just a string"`,
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conversations, err := parseImport([]byte(test.data))
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want an error: %v", err, test.err)
+			}
+
+			var titles []string
+			for _, conversation := range conversations {
+				titles = append(titles, conversation.Title)
+			}
+			if !reflect.DeepEqual(titles, test.titles) {
+				t.Errorf("titles = %q, want %q", titles, test.titles)
+			}
+		})
+	}
+}
+
+func TestChatGPTMessages(t *testing.T) {
+	conversations := fixtureConversations(t)
+	if len(conversations) != 3 {
+		t.Fatalf("%d conversations in the fixture, want 3", len(conversations))
+	}
+
+	type message struct {
+		role, content, model string
+		createdAt            int64
+	}
+	tests := []struct {
+		name      string
+		title     string
+		updatedAt int64
+		messages  []message
+	}{
+		{
+			// The branch of current_node, without the hidden system message,
+			// the first answer that was regenerated and the tool call
+			name:      "current branch",
+			title:     "Goroutine leak",
+			updatedAt: 1700000300,
+			messages: []message{
+				{"user", "[image]\nWhy does this goroutine leak?", "", 1700000010},
+				{"assistant", "The channel is never closed.", "gpt-4o", 1700000040},
+				{"user", "Thanks!", "", 1700000050},
+				{"assistant", "You're welcome.", "gpt-4o", 1700000060},
+			},
+		},
+		{
+			name:      "newest leaf without current_node",
+			title:     "Edited question",
+			updatedAt: 1700100100,
+			messages: []message{
+				{"user", "What is 2+2?", "", 1700100010},
+				{"assistant", "4", "gpt-3.5-turbo", 1700100030},
+			},
+		},
+		{
+			name:      "no messages",
+			title:     "Never started",
+			updatedAt: 1700200000,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := conversations[i].session()
+			if session.Title != test.title {
+				t.Errorf("title = %q, want %q", session.Title, test.title)
+			}
+			if session.UpdatedAt.Unix() != test.updatedAt {
+				t.Errorf("updated at %s, want %s", session.UpdatedAt, time.Unix(test.updatedAt, 0))
+			}
+
+			var messages []message
+			for _, m := range session.Messages {
+				messages = append(messages, message{m.Role, m.Content, m.Model, m.CreatedAt.Unix()})
+			}
+			if !reflect.DeepEqual(messages, test.messages) {
+				t.Errorf("messages = %+v\nwant %+v", messages, test.messages)
+			}
+		})
+	}
+}
+
+func TestChatGPTMessagesCycle(t *testing.T) {
+	conversations, err := parseImport([]byte(`{"current_node":"a","mapping":{
+		"a":{"parent":"b","children":["b"],"message":{"author":{"role":"user"},"content":{"content_type":"text","parts":["ping"]}}},
+		"b":{"parent":"a","children":["a"],"message":{"author":{"role":"assistant"},"content":{"content_type":"text","parts":["pong"]}}}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := conversations[0].chatGPTMessages(); len(messages) > 3 {
+		t.Errorf("%d messages read from a tree of 2", len(messages))
+	}
+}
+
+func TestImportSessionsDedupe(t *testing.T) {
+	home := useTempHome(t)
+	countSessions := func() int {
+		t.Helper()
+		infos, _, err := db.ListSessions(-1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(infos)
+	}
+
+	if err := ImportSessions([]string{"testdata/conversations.json"}); err != nil {
+		t.Fatal(err)
+	}
+	// The conversation without messages isn't imported
+	if count := countSessions(); count != 2 {
+		t.Fatalf("%d sessions after the import, want 2", count)
+	}
+
+	// Importing the export again changes nothing
+	if err := ImportSessions([]string{"testdata/conversations.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if count := countSessions(); count != 2 {
+		t.Errorf("%d sessions after importing again, want 2", count)
+	}
+
+	// Neither does the same conversation from another tool, only the roles
+	// and contents count
+	jsonl := filepath.Join(home, "edited.jsonl")
+	line := `{"messages":[{"role":"user","content":"What is 2+2?"},{"role":"assistant","content":"4","model":"other"}]}` + "\n"
+	if err := os.WriteFile(jsonl, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportSessions([]string{jsonl}); err != nil {
+		t.Fatal(err)
+	}
+	if count := countSessions(); count != 2 {
+		t.Errorf("%d sessions after importing the JSONL copy, want 2", count)
+	}
+
+	// An imported chat that went on is still recognized by the hash it was
+	// imported with
+	infos, _, err := db.ListSessions(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &appEnv{model: "gpt-4"}
+	if app.currentSession, err = db.GetSession(infos[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	app.currentSession.Messages = append(app.currentSession.Messages, createMessage("user", "one more"))
+	if err := app.saveSession(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportSessions([]string{"testdata/conversations.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if count := countSessions(); count != 2 {
+		t.Errorf("%d sessions after importing a chat that went on, want 2", count)
+	}
+
+	// A different conversation is imported
+	line = `{"messages":[{"role":"user","content":"What is 2+3?"},{"role":"assistant","content":"5"}]}` + "\n"
+	if err := os.WriteFile(jsonl, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportSessions([]string{jsonl}); err != nil {
+		t.Fatal(err)
+	}
+	if count := countSessions(); count != 3 {
+		t.Errorf("%d sessions after importing a new conversation, want 3", count)
+	}
+}
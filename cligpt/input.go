@@ -0,0 +1,279 @@
+package cligpt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+)
+
+const (
+	// Piped input above this size is cut off
+	maxStdinBytes = 100 * 1024
+	stdinStart    = "----- BEGIN STDIN -----"
+	stdinEnd      = "----- END STDIN -----"
+)
+
+// isPiped reports whether f is a pipe or a redirected file. Reading from a
+// terminal would block until the user types something.
+func isPiped(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
+
+// ReadStdin reads the input piped to the command, which is sent along with the
+// prompt. Since stdin is used up, chat input is read from the terminal instead,
+// it is opened when the chat asks for the next message.
+func (app *appEnv) ReadStdin() error {
+	if !isPiped(os.Stdin) {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(os.Stdin, maxStdinBytes+1))
+	if err != nil {
+		return fmt.Errorf("error reading stdin: %w", err)
+	}
+
+	if len(data) > maxStdinBytes {
+		data = data[:maxStdinBytes]
+		// Don't leave half of a multi-byte character at the end
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+			if r, size := utf8.DecodeLastRune(data); r != utf8.RuneError || size > 1 {
+				break
+			}
+			data = data[:len(data)-1]
+		}
+		fmt.Fprintf(os.Stderr, "Warning: stdin is larger than %d KB, the rest was cut off\n", maxStdinBytes/1024)
+	}
+
+	app.stdin = string(data)
+	app.stdinPiped = true
+
+	return nil
+}
+
+// openTerminal reads the chat input from the terminal once stdin is used up
+// by the piped input.
+func (app *appEnv) openTerminal() {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		// No terminal to chat in, the chat ends after the first answer
+		app.input = bufio.NewScanner(strings.NewReader(""))
+		return
+	}
+	app.tty = tty
+	app.input = bufio.NewScanner(tty)
+}
+
+// withStdin adds the piped input to the prompt. Without a prompt the piped
+// input is the prompt itself.
+func withStdin(prompt string, stdin string) string {
+	if strings.TrimSpace(stdin) == "" {
+		return prompt
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return stdin
+	}
+
+	return strings.TrimSpace(prompt) + "\n\n" + stdinStart + "\n" + strings.TrimRight(stdin, "\n") + "\n" + stdinEnd
+}
+
+// getUserInput reads a message of chat input, ok is false once the input
+// ends. A message spans several lines between """ fences, or when its lines
+// are joined with Alt-Enter or pasted at once.
+func (app *appEnv) getUserInput() (input string, ok bool) {
+	first, ok := app.readInputLine(app.inputPrompt())
+	if !ok {
+		return "", false
+	}
+
+	rest, fenced := strings.CutPrefix(strings.TrimSpace(first), fence)
+	if !fenced {
+		app.saveHistory(first)
+		return first, true
+	}
+	if body, closed := strings.CutSuffix(rest, fence); closed {
+		app.saveHistory(body)
+		return body, true
+	}
+
+	var lines []string
+	if rest != "" {
+		lines = append(lines, rest)
+	}
+	for {
+		line, ok := app.readInputLine("... ")
+		if !ok {
+			break
+		}
+		if body, closed := strings.CutSuffix(strings.TrimRight(line, " \t"), fence); closed {
+			if body != "" {
+				lines = append(lines, body)
+			}
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	input = strings.Join(lines, "\n")
+	app.saveHistory(input)
+
+	return input, true
+}
+
+// readInputLine reads a line of input after the prompt, with the line editor
+// when the input is a terminal.
+func (app *appEnv) readInputLine(prompt string) (string, bool) {
+	if app.input == nil && IsTerminal(os.Stdin) {
+		if input, ok, err := app.readLine(prompt); err == nil {
+			return input, ok
+		}
+	}
+	if app.input == nil && app.stdinPiped {
+		app.openTerminal()
+	}
+	if app.input == nil {
+		app.input = bufio.NewScanner(os.Stdin)
+	}
+
+	fmt.Print(prompt)
+	if !app.input.Scan() {
+		fmt.Println()
+		return "", false
+	}
+
+	return app.input.Text(), true
+}
+
+// readLine reads a line from the terminal with a line editor, which keeps the
+// history in ~/.cligpt/history and completes the slash commands on Tab. Ctrl-C
+// clears the line, or exits on an empty one.
+func (app *appEnv) readLine(prompt string) (string, bool, error) {
+	if app.line == nil {
+		line, err := readline.NewEx(&readline.Config{
+			Prompt:                 prompt,
+			HistoryFile:            historyFile(),
+			DisableAutoSaveHistory: true,
+			AutoComplete:           commandCompleter{app: app},
+			Painter:                linePainter{},
+			InterruptPrompt:        "^C",
+			Stdin:                  readline.NewCancelableStdin(&keyReader{r: os.Stdin}),
+		})
+		if err != nil {
+			return "", false, err
+		}
+		app.line = line
+	}
+
+	app.line.SetPrompt(prompt)
+	fmt.Print(bracketedPasteOn)
+	defer fmt.Print(bracketedPasteOff)
+	for {
+		input, err := app.line.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			if input == "" {
+				app.closeInput()
+				os.Exit(130)
+			}
+			continue
+		}
+		if err != nil {
+			return "", false, nil
+		}
+
+		return fromEditor.Replace(input), true, nil
+	}
+}
+
+// saveHistory adds a message to the history of the line editor, its line
+// breaks are kept so it comes back as a single entry.
+func (app *appEnv) saveHistory(input string) {
+	if app.line == nil || strings.TrimSpace(input) == "" {
+		return
+	}
+
+	app.line.SaveHistory(toEditor.Replace(input))
+}
+
+// closeInput restores the terminal after the chat and closes the one opened
+// for piped input.
+func (app *appEnv) closeInput() {
+	if app.line != nil {
+		fmt.Print(bracketedPasteOff)
+		app.line.Close()
+		app.line = nil
+	}
+	if app.tty != nil {
+		app.tty.Close()
+		app.tty = nil
+		app.input = nil
+	}
+}
+
+// commandCompleter completes the names of the slash commands and their last
+// argument.
+type commandCompleter struct {
+	app *appEnv
+}
+
+func (c commandCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	typed := string(line[:pos])
+	if !strings.HasPrefix(typed, "/") {
+		return nil, 0
+	}
+
+	var word string
+	var candidates []string
+	name, args, hasArgs := strings.Cut(typed, " ")
+	if !hasArgs {
+		word = name
+		for _, command := range chatCommands {
+			candidates = append(candidates, "/"+command.name+" ")
+		}
+	} else {
+		command, ok := findCommand(name)
+		if !ok || command.complete == nil {
+			return nil, 0
+		}
+		word = args[strings.LastIndex(args, " ")+1:]
+		candidates = command.complete(c.app, word)
+	}
+
+	var suffixes [][]rune
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			suffixes = append(suffixes, []rune(candidate[len(word):]))
+		}
+	}
+
+	return suffixes, len([]rune(word))
+}
+
+// inputPrompt shows how much of the context window is left before the "> ".
+func (app *appEnv) inputPrompt() string {
+	remaining, err := app.remainingContext()
+	if err != nil {
+		return "> "
+	}
+
+	return "[" + formatTokens(remaining) + " left] > "
+}
+
+func formatTokens(tokens int) string {
+	if tokens >= 1000 || tokens <= -1000 {
+		return strconv.FormatFloat(float64(tokens)/1000, 'f', 1, 64) + "k"
+	}
+
+	return strconv.Itoa(tokens)
+}
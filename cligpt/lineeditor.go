@@ -0,0 +1,176 @@
+package cligpt
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	historyName = "history"
+	// fence starts and ends a message of several lines
+	fence = `"""`
+
+	// newlineRune and tabRune stand in for line breaks and tabs in the line
+	// editor, which would send the line and complete it otherwise
+	newlineRune = '\uE000'
+	tabRune     = '\uE001'
+
+	bracketedPasteOn  = "\x1b[?2004h"
+	bracketedPasteOff = "\x1b[?2004l"
+)
+
+var (
+	fromEditor = strings.NewReplacer(string(newlineRune), "\n", string(tabRune), "\t")
+	toEditor   = strings.NewReplacer("\n", string(newlineRune), "\t", string(tabRune))
+)
+
+func getHistoryPath() (string, error) {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homedir, folderName, historyName), nil
+}
+
+// historyFile returns the path of the input history, which is created
+// readable by the user only. It is empty when the history can't be kept.
+func historyFile() string {
+	path, err := getHistoryPath()
+	if err != nil {
+		return ""
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return ""
+	}
+	f.Close()
+
+	return path
+}
+
+// Terminal input sequences rewritten by keyReader
+var (
+	altEnter       = []string{"\x1b\r", "\x1b\n"}
+	pasteStart     = "\x1b[200~"
+	pasteEnd       = "\x1b[201~"
+	keySequences   = append([]string{pasteStart, pasteEnd}, altEnter...)
+	newlineEncoded = []byte(string(newlineRune))
+	tabEncoded     = []byte(string(tabRune))
+)
+
+// keyReader rewrites the terminal input for the line editor. Alt-Enter, and
+// the line breaks of a bracketed paste, become newlineRune and the tabs of a
+// paste tabRune, so a message of several lines can be typed or pasted and
+// edited as one line.
+type keyReader struct {
+	r       io.Reader
+	pending []byte
+	out     []byte
+	pasting bool
+	// lastCR skips the \n of a pasted \r\n
+	lastCR bool
+}
+
+func (k *keyReader) Read(p []byte) (int, error) {
+	buf := make([]byte, 1024)
+	for len(k.out) == 0 {
+		n, err := k.r.Read(buf)
+		k.pending = append(k.pending, buf[:n]...)
+		k.rewrite()
+		if err != nil {
+			// What is left can't be the start of a sequence anymore
+			k.out = append(k.out, k.pending...)
+			k.pending = nil
+			if len(k.out) == 0 {
+				return 0, err
+			}
+		}
+	}
+
+	n := copy(p, k.out)
+	k.out = k.out[n:]
+
+	return n, nil
+}
+
+// rewrite moves the pending input to the output, up to the start of an
+// escape sequence that hasn't been read whole yet.
+func (k *keyReader) rewrite() {
+	for len(k.pending) > 0 {
+		if k.pending[0] == '\x1b' {
+			sequence, partial := matchSequence(k.pending)
+			if partial {
+				return
+			}
+			if sequence != "" {
+				k.pending = k.pending[len(sequence):]
+				switch sequence {
+				case pasteStart:
+					k.pasting = true
+				case pasteEnd:
+					k.pasting = false
+				default:
+					k.out = append(k.out, newlineEncoded...)
+				}
+				k.lastCR = false
+				continue
+			}
+		}
+
+		b := k.pending[0]
+		k.pending = k.pending[1:]
+		switch {
+		case k.pasting && b == '\r':
+			k.out = append(k.out, newlineEncoded...)
+		case k.pasting && b == '\n':
+			if !k.lastCR {
+				k.out = append(k.out, newlineEncoded...)
+			}
+		case k.pasting && b == '\t':
+			k.out = append(k.out, tabEncoded...)
+		default:
+			k.out = append(k.out, b)
+		}
+		k.lastCR = b == '\r'
+	}
+}
+
+// matchSequence returns the sequence the input starts with, or partial when
+// the input is the start of one.
+func matchSequence(input []byte) (sequence string, partial bool) {
+	for _, sequence := range keySequences {
+		if bytes.HasPrefix(input, []byte(sequence)) {
+			return sequence, false
+		}
+		if bytes.HasPrefix([]byte(sequence), input) {
+			partial = true
+		}
+	}
+
+	return "", partial
+}
+
+// linePainter shows the line breaks of a message as ↵, and its tabs as
+// spaces, in the line editor.
+type linePainter struct{}
+
+func (linePainter) Paint(line []rune, pos int) []rune {
+	painted := make([]rune, len(line))
+	for i, r := range line {
+		switch r {
+		case newlineRune:
+			painted[i] = '↵'
+		case tabRune:
+			painted[i] = ' '
+		default:
+			painted[i] = r
+		}
+	}
+
+	return painted
+}
@@ -0,0 +1,126 @@
+package cligpt
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestKeyReader(t *testing.T) {
+	const (
+		nl  = string(newlineRune)
+		tab = string(tabRune)
+	)
+
+	tests := []struct {
+		name  string
+		input string
+		out   string
+	}{
+		{
+			name:  "typed line",
+			input: "hello\r",
+			out:   "hello\r",
+		},
+		{
+			name:  "alt-enter",
+			input: "one\x1b\rtwo\x1b\nthree\r",
+			out:   "one" + nl + "two" + nl + "three\r",
+		},
+		{
+			name:  "tab outside a paste completes",
+			input: "/mo\t",
+			out:   "/mo\t",
+		},
+		{
+			name:  "arrow keys pass through",
+			input: "ab\x1b[D\x1b[Dc\x1b[A\r",
+			out:   "ab\x1b[D\x1b[Dc\x1b[A\r",
+		},
+		{
+			name:  "paste",
+			input: "\x1b[200~func main() {\n\tprintln()\n}\x1b[201~\r",
+			out:   "func main() {" + nl + tab + "println()" + nl + "}\r",
+		},
+		{
+			name:  "paste with CRLF",
+			input: "\x1b[200~one\r\ntwo\r\n\x1b[201~",
+			out:   "one" + nl + "two" + nl,
+		},
+		{
+			name:  "paste with CR",
+			input: "\x1b[200~one\rtwo\x1b[201~",
+			out:   "one" + nl + "two",
+		},
+		{
+			name:  "blank lines of a paste are kept",
+			input: "\x1b[200~a\n\n\r\n\r\rb\x1b[201~",
+			out:   "a" + nl + nl + nl + nl + nl + "b",
+		},
+		{
+			name:  "typing after a paste",
+			input: "\x1b[200~a\nb\x1b[201~ and c\r",
+			out:   "a" + nl + "b and c\r",
+		},
+		{
+			name:  "multi-byte characters",
+			input: "\x1b[200~héllo\n€\x1b[201~",
+			out:   "héllo" + nl + "€",
+		},
+		{
+			name:  "cut off in a sequence",
+			input: "abc\x1b[20",
+			out:   "abc\x1b[20",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, r := range []io.Reader{strings.NewReader(test.input), iotest.OneByteReader(strings.NewReader(test.input))} {
+				out, err := ioutil.ReadAll(&keyReader{r: r})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(out) != test.out {
+					t.Errorf("out = %q, want %q", out, test.out)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyReaderSmallBuffer(t *testing.T) {
+	k := &keyReader{r: strings.NewReader("\x1b[200~a\nb\x1b[201~")}
+
+	var out []byte
+	p := make([]byte, 1)
+	for {
+		n, err := k.Read(p)
+		out = append(out, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := "a" + string(newlineRune) + "b"; string(out) != want {
+		t.Errorf("out = %q, want %q", out, want)
+	}
+}
+
+// Messages go into the line editor and come out of it the same.
+func TestEditorReplacers(t *testing.T) {
+	for _, message := range []string{"", "one line", "two\nlines", "\tindented\n\n\tcode\n"} {
+		edited := toEditor.Replace(message)
+		if strings.ContainsAny(edited, "\n\t") {
+			t.Errorf("%q is %q in the editor, it still has line breaks or tabs", message, edited)
+		}
+		if back := fromEditor.Replace(edited); back != message {
+			t.Errorf("%q came back as %q", message, back)
+		}
+	}
+}
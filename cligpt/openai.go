@@ -0,0 +1,331 @@
+package cligpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/eitamonya/cligpt/sse"
+	"github.com/eitamonya/cligpt/types"
+)
+
+const (
+	OPENAI_BASE_URL string = "https://api.openai.com/v1"
+	CHAT_PATH       string = "/chat/completions"
+	IMAGE_PATH      string = "/images/generations"
+	MODELS_PATH     string = "/models"
+)
+
+type ChatResponseBody struct {
+	Choices []struct {
+		Message types.Message `json:"message"`
+	}
+	Usage *ChatUsage `json:"usage"`
+}
+
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *ChatUsage) toUsage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+}
+
+type ChatMessage struct {
+	Role string `json:"role"`
+	// Content is a string, or a list of parts for messages with images
+	Content interface{} `json:"content"`
+}
+
+type ChatRequestBody struct {
+	Model         string         `json:"model"`
+	Messages      []ChatMessage  `json:"messages"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	Temperature   float64        `json:"temperature"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+}
+
+type StreamOptions struct {
+	// IncludeUsage asks for a last chunk holding the usage of the request
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type Chunk struct {
+	Error   *apiErrorBody `json:"error"`
+	Usage   *ChatUsage    `json:"usage"`
+	Choices []struct {
+		FinishReason string `json:"finish_reason"`
+		Delta        struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+type ModelsResponseBody struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+type ImageRequestBody struct {
+	Prompt  string `json:"prompt"`
+	N       int    `json:"n"`
+	Size    string `json:"size"`
+	Model   string `json:"model"`
+	Quality string `json:"quality"`
+	Style   string `json:"style"`
+}
+
+// openAIProvider talks to the OpenAI chat completions API or any server that
+// implements it, e.g. Ollama, vLLM, LM Studio or LocalAI.
+type openAIProvider struct {
+	client  *apiClient
+	token   string
+	baseURL string
+	headers map[string]string
+}
+
+func newOpenAIProvider(config Config) *openAIProvider {
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = OPENAI_BASE_URL
+	}
+
+	return &openAIProvider{
+		client:  newAPIClient(config.Retry),
+		token:   config.Token,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		headers: config.Headers,
+	}
+}
+
+func (p *openAIProvider) newRequest(ctx context.Context, method string, path string, body interface{}) (*http.Request, error) {
+	req, err := newJSONRequest(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Local servers usually don't need a token
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	for key, value := range p.headers {
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
+
+func buildCompletionRequest(ctx context.Context, p *openAIProvider, request CompletionRequest, stream bool) (*http.Request, error) {
+	var reqBody ChatRequestBody
+
+	reqBody.Model = request.Model
+	reqBody.Stream = stream
+	if stream {
+		reqBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	reqBody.Temperature = request.Temperature
+	reqBody.MaxTokens = request.MaxTokens
+	for _, message := range request.Messages {
+		reqBody.Messages = append(reqBody.Messages, toChatMessage(message))
+	}
+
+	return p.newRequest(ctx, "POST", CHAT_PATH, reqBody)
+}
+
+func toChatMessage(message types.Message) ChatMessage {
+	if len(message.Parts) > 0 {
+		return ChatMessage{Role: message.Role, Content: message.Parts}
+	}
+
+	return ChatMessage{Role: message.Role, Content: message.Content}
+}
+
+func parseCompletionResponse(resp *http.Response) (ChatResponseBody, []byte, error) {
+	var responseBody ChatResponseBody
+
+	if err := checkResponse(resp); err != nil {
+		return responseBody, nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return responseBody, nil, fmt.Errorf("%w: error reading response body: %v", ErrNetwork, err)
+	}
+
+	if err := json.Unmarshal(body, &responseBody); err != nil {
+		return responseBody, nil, fmt.Errorf("%w: error parsing response body: %v", ErrAPI, err)
+	}
+
+	return responseBody, body, nil
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, request CompletionRequest) (CompletionResponse, error) {
+	req, err := buildCompletionRequest(ctx, p, request, false)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+
+	resp, err := p.client.do(req)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, raw, err := parseCompletionResponse(resp)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+
+	var content string
+	if len(responseBody.Choices) > 0 {
+		content = responseBody.Choices[0].Message.Content
+	}
+
+	return CompletionResponse{Content: content, Raw: raw, Usage: responseBody.Usage.toUsage()}, nil
+}
+
+// parseMessageChunks reads the streamed chunks until the [DONE] sentinel, a
+// stream that ends before it was cut off. The usage comes in a last chunk
+// without choices, other chunks without choices, like the content filter
+// results some servers send, are skipped.
+func parseMessageChunks(ctx context.Context, resp *http.Response, onDelta func(string)) (CompletionResponse, error) {
+	var response CompletionResponse
+
+	if err := checkResponse(resp); err != nil {
+		return response, err
+	}
+
+	reader := sse.NewReader(resp.Body)
+	for {
+		event, err := reader.Next()
+		if err == io.EOF {
+			return response, fmt.Errorf("%w: stream ended early", ErrNetwork)
+		}
+		if err != nil {
+			return response, streamError(ctx, err)
+		}
+
+		if event.Data == "[DONE]" {
+			return response, nil
+		}
+
+		var chunk Chunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			return response, fmt.Errorf("%w: error parsing response body: %v", ErrAPI, err)
+		}
+		if chunk.Error != nil {
+			return response, newAPIError(chunk.Error)
+		}
+		if chunk.Usage != nil {
+			response.Usage = chunk.Usage.toUsage()
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				onDelta(choice.Delta.Content)
+				response.Content += choice.Delta.Content
+			}
+		}
+	}
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, request CompletionRequest, onDelta func(string)) (CompletionResponse, error) {
+	req, err := buildCompletionRequest(ctx, p, request, true)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+
+	resp, err := p.client.do(req)
+	if err != nil {
+		return CompletionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	return parseMessageChunks(ctx, resp, onDelta)
+}
+
+func (p *openAIProvider) ListModels(ctx context.Context) ([]string, error) {
+	req, err := p.newRequest(ctx, "GET", MODELS_PATH, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return listModels(p.client, req)
+}
+
+// listModels fetches a model list in the {"data": [{"id": ...}]} shape shared
+// by the OpenAI and Anthropic APIs.
+func listModels(client *apiClient, req *http.Request) ([]string, error) {
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var responseBody ModelsResponseBody
+	if err := json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
+		return nil, fmt.Errorf("%w: error parsing response body: %v", ErrAPI, err)
+	}
+
+	var ids []string
+	for _, model := range responseBody.Data {
+		ids = append(ids, model.ID)
+	}
+
+	return ids, nil
+}
+
+func buildImageRequest(ctx context.Context, p *openAIProvider, prompt string, image Image) (*http.Request, error) {
+	var reqBody ImageRequestBody
+
+	image = image.withDefaults()
+
+	reqBody.Prompt = prompt
+	reqBody.N = 1
+	reqBody.Size = image.Size
+	reqBody.Model = image.Model
+	reqBody.Quality = image.Quality
+	reqBody.Style = image.Style
+
+	return p.newRequest(ctx, "POST", IMAGE_PATH, reqBody)
+}
+
+func (p *openAIProvider) GenerateImage(ctx context.Context, prompt string, image Image) ([]byte, error) {
+	req, err := buildImageRequest(ctx, p, prompt, image)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading response body: %v", ErrNetwork, err)
+	}
+
+	return body, nil
+}
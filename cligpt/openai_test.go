@@ -0,0 +1,136 @@
+package cligpt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// chunk formats a streamed chunk as the API sends it.
+func chunk(data string) string {
+	return "data: " + data + "\n\n"
+}
+
+func TestParseMessageChunks(t *testing.T) {
+	role := chunk(`{"choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`)
+	hello := chunk(`{"choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`)
+	world := chunk(`{"choices":[{"index":0,"delta":{"content":", world"},"finish_reason":null}]}`)
+	finish := chunk(`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`)
+	usage := chunk(`{"choices":[],"usage":{"prompt_tokens":9,"completion_tokens":3,"total_tokens":12}}`)
+	// Azure sends the prompt filter results first, without choices
+	filter := chunk(`{"choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{"hate":{"filtered":false,"severity":"safe"}}}]}`)
+	done := chunk("[DONE]")
+
+	tests := []struct {
+		name    string
+		status  int
+		stream  string
+		content string
+		usage   Usage
+		err     error
+	}{
+		{
+			name:    "complete",
+			stream:  role + hello + world + finish + usage + done,
+			content: "Hello, world",
+			usage:   Usage{PromptTokens: 9, CompletionTokens: 3},
+		},
+		{
+			name:    "content filter chunks",
+			stream:  filter + role + hello + finish + done,
+			content: "Hello",
+		},
+		{
+			name:    "keep-alives and CRLF",
+			stream:  strings.ReplaceAll(": keep-alive\n\n"+hello+": keep-alive\n\n"+world+done, "\n", "\r\n"),
+			content: "Hello, world",
+		},
+		{
+			name:    "nothing is read after DONE",
+			stream:  hello + done + world + chunk("not json"),
+			content: "Hello",
+		},
+		{
+			name:    "stream without DONE",
+			stream:  hello + world + usage,
+			content: "Hello, world",
+			usage:   Usage{PromptTokens: 9, CompletionTokens: 3},
+			err:     ErrNetwork,
+		},
+		{
+			name:    "stream cut off in a chunk",
+			stream:  hello + `data: {"choices":[{"index":0,"delta":{"content":", wor`,
+			content: "Hello",
+			err:     ErrNetwork,
+		},
+		{
+			name:    "error chunk",
+			stream:  hello + chunk(`{"error":{"type":"server_error","code":"rate_limit_exceeded","message":"slow down"}}`),
+			content: "Hello",
+			err:     ErrRateLimited,
+		},
+		{
+			name:   "error status",
+			status: http.StatusUnauthorized,
+			stream: `{"error":{"type":"invalid_request_error","code":"invalid_api_key","message":"Incorrect API key"}}`,
+			err:    ErrAuth,
+		},
+		{
+			name:    "invalid JSON",
+			stream:  hello + chunk(`{"choices":`),
+			content: "Hello",
+			err:     ErrAPI,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := test.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			resp := &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(test.stream))}
+
+			var deltas []string
+			response, err := parseMessageChunks(context.Background(), resp, func(delta string) {
+				deltas = append(deltas, delta)
+			})
+
+			if test.err == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if response.Content != test.content {
+				t.Errorf("content = %q, want %q", response.Content, test.content)
+			}
+			if strings.Join(deltas, "") != test.content {
+				t.Errorf("deltas = %q, want %q", deltas, test.content)
+			}
+			if response.Usage != test.usage {
+				t.Errorf("usage = %+v, want %+v", response.Usage, test.usage)
+			}
+		})
+	}
+}
+
+func TestParseMessageChunksInterrupted(t *testing.T) {
+	body := io.MultiReader(
+		strings.NewReader(chunk(`{"choices":[{"index":0,"delta":{"content":"Hello"}}]}`)+"data: {"),
+		iotest.ErrReader(io.ErrUnexpectedEOF),
+	)
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(body)}
+
+	response, err := parseMessageChunks(context.Background(), resp, func(string) {})
+	if !errors.Is(err, ErrNetwork) {
+		t.Fatalf("error = %v, want ErrNetwork", err)
+	}
+	if response.Content != "Hello" {
+		t.Errorf("content = %q, the answer so far should be kept", response.Content)
+	}
+}
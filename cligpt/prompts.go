@@ -2,9 +2,10 @@ package cligpt
 
 import (
 	"errors"
-	"log"
+	"fmt"
 
 	"github.com/manifoldco/promptui"
+	"github.com/manifoldco/promptui/list"
 )
 
 type isValidInputString func(string) bool
@@ -18,6 +19,9 @@ type promptInputContent struct {
 type promptSelectContent struct {
 	label        string
 	selectValues []string
+	// searcher turns on filtering the values by typing after pressing /
+	searcher list.Searcher
+	size     int
 }
 
 type promptSelectReturnType struct {
@@ -25,7 +29,16 @@ type promptSelectReturnType struct {
 	value string
 }
 
-func promptGetInput(pc promptInputContent) string {
+// promptError turns Ctrl-C and Ctrl-D in a prompt into ErrAborted.
+func promptError(err error) error {
+	if errors.Is(err, promptui.ErrInterrupt) || errors.Is(err, promptui.ErrEOF) || errors.Is(err, promptui.ErrAbort) {
+		return ErrAborted
+	}
+
+	return fmt.Errorf("prompt failed: %w", err)
+}
+
+func promptGetInput(pc promptInputContent) (string, error) {
 	validate := func(input string) error {
 		if len(input) <= 0 {
 			return errors.New(pc.errorMsg)
@@ -51,27 +64,29 @@ func promptGetInput(pc promptInputContent) string {
 
 	result, err := prompt.Run()
 	if err != nil {
-		log.Fatal(err)
+		return "", promptError(err)
 	}
 
-	return result
+	return result, nil
 }
 
-func promptGetSelect(pc promptSelectContent) promptSelectReturnType {
+func promptGetSelect(pc promptSelectContent) (promptSelectReturnType, error) {
 	index := 0
 	var err error
 	var result string
 
 	prompt := promptui.Select{
-		Label: pc.label,
-		Items: pc.selectValues,
+		Label:    pc.label,
+		Items:    pc.selectValues,
+		Searcher: pc.searcher,
+		Size:     pc.size,
 	}
 
 	index, result, err = prompt.Run()
 
 	if err != nil {
-		log.Fatal(err)
+		return promptSelectReturnType{}, promptError(err)
 	}
 
-	return promptSelectReturnType{index: index, value: result}
+	return promptSelectReturnType{index: index, value: result}, nil
 }
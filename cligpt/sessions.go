@@ -0,0 +1,363 @@
+package cligpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+	"github.com/manifoldco/promptui/list"
+)
+
+// Number of sessions offered by the picker of chat list
+const pickerSessions = 500
+
+// sessionSearcher filters the picker by the session names and by the search
+// index of the messages, which is queried once per input.
+func sessionSearcher(sessions []types.SessionInfo, names []string) list.Searcher {
+	var lastInput string
+	var matches map[int]bool
+
+	return func(input string, index int) bool {
+		input = strings.TrimSpace(input)
+		if input == "" {
+			return true
+		}
+
+		if matches == nil || input != lastInput {
+			var err error
+			// The last word is still being typed
+			if matches, err = db.SearchSessions(input + "*"); err != nil {
+				matches = map[int]bool{}
+			}
+			lastInput = input
+		}
+
+		return matches[sessions[index].ID] || strings.Contains(strings.ToLower(names[index]), strings.ToLower(input))
+	}
+}
+
+// sessionName is the title of a session, or the start of its first user
+// message when it has none.
+func sessionName(title string, firstMessage string) string {
+	if title != "" {
+		return title
+	}
+
+	name := strings.Join(strings.Fields(firstMessage), " ")
+	if len(name) > 90 {
+		name = strings.TrimSpace(name[:90]) + "..."
+	}
+
+	return name
+}
+
+// infoName is the name of a listed session, a fork that has no messages of
+// its own yet is named after its parent.
+func infoName(session types.SessionInfo) string {
+	name := sessionName(session.Title, session.Preview)
+	if name == "" && session.ParentID != 0 {
+		return fmt.Sprintf("Fork of #%d", session.ParentID)
+	}
+
+	return name
+}
+
+// sessionTree orders the sessions so that forks follow their parent, and
+// returns how deep each of them is in the tree. Sessions whose parent isn't
+// listed are roots, the order is kept among siblings.
+func sessionTree(sessions []types.SessionInfo) ([]types.SessionInfo, []int) {
+	listed := map[int]bool{}
+	for _, session := range sessions {
+		listed[session.ID] = true
+	}
+
+	children := map[int][]types.SessionInfo{}
+	var roots []types.SessionInfo
+	for _, session := range sessions {
+		if session.ParentID != 0 && listed[session.ParentID] {
+			children[session.ParentID] = append(children[session.ParentID], session)
+		} else {
+			roots = append(roots, session)
+		}
+	}
+
+	ordered := make([]types.SessionInfo, 0, len(sessions))
+	depths := make([]int, 0, len(sessions))
+	var walk func(session types.SessionInfo, depth int)
+	walk = func(session types.SessionInfo, depth int) {
+		ordered = append(ordered, session)
+		depths = append(depths, depth)
+		for _, child := range children[session.ID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+
+	return ordered, depths
+}
+
+// treeLine is the line of a session in the picker, indented under its
+// parent.
+func treeLine(session types.SessionInfo, depth int) string {
+	indent := ""
+	if depth > 0 {
+		indent = strings.Repeat("   ", depth-1) + "└─ "
+	}
+
+	return fmt.Sprintf("%s#%d  %s", indent, session.ID, infoName(session))
+}
+
+func firstUserMessage(messages []types.Message) string {
+	for _, message := range messages {
+		if message.Role == "user" {
+			return message.Content
+		}
+	}
+
+	return ""
+}
+
+// printSession prints the transcript of a session. The user messages are
+// numbered by turn, for /fork and /edit.
+func printSession(session types.Session) {
+	turn := 0
+	for _, e := range session.Messages {
+		if e.Role == "user" {
+			turn++
+			if len(e.Files) > 0 {
+				fmt.Println("FILES: ", strings.Join(e.Files, ", "))
+			}
+			fmt.Printf("USER #%d:  %s\n", turn, e.Content+strings.Repeat(" [image]", e.Images())+"\n")
+		} else if e.Role == "system" {
+			fmt.Println("SYSTEM: ", e.Content+"\n")
+		} else {
+			if e.Truncated {
+				e.Content += " [truncated]"
+			}
+			printResponse(e.Content + "\n")
+		}
+	}
+}
+
+type sessionPage struct {
+	Sessions []types.SessionInfo `json:"sessions"`
+	Total    int                 `json:"total"`
+	Page     int                 `json:"page"`
+	Limit    int                 `json:"limit"`
+}
+
+// ListSessions prints a page of the saved sessions, most recently updated
+// first.
+func ListSessions(page int, limit int, asJSON bool) error {
+	if page < 1 || limit < 1 {
+		return fmt.Errorf("--page and --limit must be at least 1")
+	}
+
+	sessions, total, err := db.ListSessions(limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if sessions == nil {
+			sessions = []types.SessionInfo{}
+		}
+		output, err := json.MarshalIndent(sessionPage{Sessions: sessions, Total: total, Page: page, Limit: limit}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("No saved sessions")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPARENT\tUPDATED\tMESSAGES\tTITLE")
+	for _, session := range sessions {
+		parent := "-"
+		if session.ParentID != 0 {
+			parent = strconv.Itoa(session.ParentID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", session.ID, parent, session.UpdatedAt.Local().Format("2006-01-02 15:04"), session.Messages, infoName(session))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if pages := (total + limit - 1) / limit; page < pages {
+		fmt.Printf("\nPage %d of %d, use --page %d for more\n", page, pages, page+1)
+	}
+
+	return nil
+}
+
+// ShowSession prints a session, as a transcript or as JSON.
+func ShowSession(id int, asJSON bool) error {
+	session, err := db.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		output, err := json.MarshalIndent(session, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Printf("Session %d: %s\n", session.ID, sessionName(session.Title, firstUserMessage(session.Messages)))
+	if session.ParentID != 0 {
+		fmt.Printf("Forked from session %d\n", session.ParentID)
+	}
+	fmt.Printf("Updated %s\n\n", session.UpdatedAt.Local().Format("2006-01-02 15:04"))
+	printSession(session)
+
+	return nil
+}
+
+func RemoveSessions(ids []int) error {
+	if err := db.DeleteSessions(ids); err != nil {
+		return err
+	}
+
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = strconv.Itoa(id)
+	}
+	fmt.Println("Deleted", strings.Join(names, ", "))
+
+	return nil
+}
+
+func RenameSession(id int, title string) error {
+	if err := db.RenameSession(id, strings.TrimSpace(title)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Session %d renamed\n", id)
+
+	return nil
+}
+
+// ResumeSession continues a saved chat.
+func (app *appEnv) ResumeSession(id int) error {
+	if err := app.loadConfig(); err != nil {
+		return err
+	}
+
+	session, err := db.GetSession(id)
+	if err != nil {
+		return err
+	}
+
+	app.currentSession = session
+	printSession(app.currentSession)
+
+	return app.Chat()
+}
+
+// SearchOptions are the filters of sessions search, empty ones don't filter.
+type SearchOptions struct {
+	Query string
+	Role  string
+	Model string
+	// Since and Until take a date or a period back from now like 7d
+	Since string
+	Until string
+	Limit int
+	JSON  bool
+}
+
+const (
+	highlightStart = "\x1b[1;33m"
+	highlightEnd   = "\x1b[0m"
+)
+
+// SearchMessages prints the messages matching a full-text search, best
+// matches first.
+func SearchMessages(options SearchOptions) error {
+	if options.Role != "" && options.Role != "user" && options.Role != "assistant" && options.Role != "system" {
+		return fmt.Errorf("invalid --role %q, use user, assistant or system", options.Role)
+	}
+
+	now := time.Now()
+	since, err := parseSince(options.Since, now)
+	if err != nil {
+		return err
+	}
+	until, err := parseSince(options.Until, now)
+	if err != nil {
+		return fmt.Errorf("invalid --until %q, use a date like 2024-01-31 or a period like 7d", options.Until)
+	}
+	// A date includes the whole day
+	if _, err := time.Parse("2006-01-02", options.Until); err == nil {
+		until = until.AddDate(0, 0, 1)
+	}
+
+	search := db.Search{
+		Query: options.Query,
+		Role:  options.Role,
+		Model: options.Model,
+		Since: since,
+		Until: until,
+		Limit: options.Limit,
+	}
+	if IsTerminal(os.Stdout) && !options.JSON {
+		search.HighlightStart, search.HighlightEnd = highlightStart, highlightEnd
+	} else {
+		search.HighlightStart, search.HighlightEnd = "**", "**"
+	}
+
+	results, err := db.SearchMessages(search)
+	if err != nil {
+		return err
+	}
+
+	if options.JSON {
+		if results == nil {
+			results = []types.SearchResult{}
+		}
+		output, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No messages found")
+		return nil
+	}
+
+	for _, result := range results {
+		header := fmt.Sprintf("#%d", result.SessionID)
+		if result.SessionTitle != "" {
+			header += " " + result.SessionTitle
+		}
+		header += "  " + result.CreatedAt.Local().Format("2006-01-02 15:04") + "  " + result.Role
+		if result.Model != "" {
+			header += " (" + result.Model + ")"
+		}
+
+		fmt.Println(header)
+		fmt.Println("    " + strings.Join(strings.Fields(result.Snippet), " "))
+		fmt.Println()
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package cligpt
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// tree renders the sessions the way the picker of chat list shows them.
+func tree(sessions []types.SessionInfo) []string {
+	ordered, depths := sessionTree(sessions)
+
+	var lines []string
+	for i, session := range ordered {
+		lines = append(lines, treeLine(session, depths[i]))
+	}
+
+	return lines
+}
+
+func TestSessionTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []types.SessionInfo
+		lines    []string
+	}{
+		{
+			name: "no sessions",
+		},
+		{
+			name: "no forks",
+			sessions: []types.SessionInfo{
+				{ID: 3, Title: "three"},
+				{ID: 1, Preview: "what is   a\nmonad?"},
+				{ID: 2, Title: "two"},
+			},
+			lines: []string{"#3  three", "#1  what is a monad?", "#2  two"},
+		},
+		{
+			name: "forks follow their parent",
+			sessions: []types.SessionInfo{
+				{ID: 4, Title: "fork of two", ParentID: 2},
+				{ID: 3, Title: "three"},
+				{ID: 2, Title: "two"},
+			},
+			lines: []string{"#3  three", "#2  two", "└─ #4  fork of two"},
+		},
+		{
+			name: "nested forks",
+			sessions: []types.SessionInfo{
+				{ID: 6, Title: "grandchild", ParentID: 5},
+				{ID: 5, Title: "child", ParentID: 1},
+				{ID: 7, Title: "great-grandchild", ParentID: 6},
+				{ID: 1, Title: "root"},
+			},
+			lines: []string{"#1  root", "└─ #5  child", "   └─ #6  grandchild", "      └─ #7  great-grandchild"},
+		},
+		{
+			name: "siblings keep their order",
+			sessions: []types.SessionInfo{
+				{ID: 9, Title: "newer fork", ParentID: 1},
+				{ID: 1, Title: "root"},
+				{ID: 8, Title: "older fork", ParentID: 1},
+			},
+			lines: []string{"#1  root", "└─ #9  newer fork", "└─ #8  older fork"},
+		},
+		{
+			name: "parent not listed",
+			sessions: []types.SessionInfo{
+				{ID: 12, Title: "fork", ParentID: 11},
+				{ID: 10, Title: "other"},
+			},
+			lines: []string{"#12  fork", "#10  other"},
+		},
+		{
+			name: "fork without messages of its own",
+			sessions: []types.SessionInfo{
+				{ID: 1, Title: "root"},
+				{ID: 2, ParentID: 1},
+			},
+			lines: []string{"#1  root", "└─ #2  Fork of #1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines := tree(test.sessions)
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("tree = %q, want %q", lines, test.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package cligpt
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// requestMessages returns the messages of the current session as they are sent
+// to the model, with the summarized turns replaced by their summary.
+func (app *appEnv) requestMessages() []types.Message {
+	session := app.currentSession
+	if session.Summary == "" {
+		return session.Messages
+	}
+
+	system := leadingSystemMessages(session.Messages)
+	start := session.SummaryUpTo
+	if start < system {
+		start = system
+	}
+
+	messages := append([]types.Message{}, session.Messages[:system]...)
+	messages = append(messages, summaryMessage(session.Summary))
+	return append(messages, session.Messages[start:]...)
+}
+
+// rollSummary folds the oldest turns of the session into its summary once the
+// request grows past summarize_threshold, leaving about half of it to the
+// newest turns. The messages themselves stay in the session.
+func (app *appEnv) rollSummary(ctx context.Context) error {
+	if app.summarize_threshold <= 0 {
+		return nil
+	}
+
+	used, err := countTokens(app.model, app.requestMessages())
+	if err != nil {
+		return err
+	}
+	if used <= app.summarize_threshold {
+		return nil
+	}
+
+	summarized, err := app.foldIntoSummary(ctx, app.summarize_threshold/2, app.summarize_threshold/4)
+	if err != nil || summarized == 0 {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Summarized %d old messages\n", summarized)
+
+	return nil
+}
+
+// foldIntoSummary summarizes the oldest turns that aren't in the summary yet,
+// along with the summary so far, until the newer messages take up at most keep
+// tokens. The summary is stored with the session, so later requests reuse it.
+// It returns how many messages were added to the summary.
+func (app *appEnv) foldIntoSummary(ctx context.Context, keep int, maxTokens int) (int, error) {
+	messages := app.currentSession.Messages
+	counts, err := messageTokens(app.model, messages)
+	if err != nil {
+		return 0, err
+	}
+
+	start := app.currentSession.SummaryUpTo
+	if system := leadingSystemMessages(messages); start < system {
+		start = system
+	}
+	kept := sumTokens(counts[start:])
+
+	// Summarize whole turns, a turn starts with a user message, and always
+	// keep the newest message
+	end := start
+	for end < len(messages)-1 && (kept > keep || messages[end].Role != "user") {
+		kept -= counts[end]
+		end++
+	}
+	if end == start {
+		return 0, nil
+	}
+
+	var summarized []types.Message
+	if app.currentSession.Summary != "" {
+		summarized = append(summarized, summaryMessage(app.currentSession.Summary))
+	}
+	summarized = append(summarized, messages[start:end]...)
+
+	summary, err := app.summarize(ctx, summarized, maxTokens)
+	if err != nil {
+		return 0, err
+	}
+
+	app.currentSession.Summary = summary
+	app.currentSession.SummaryUpTo = end
+
+	if app.currentSession.ID == 0 {
+		return end - start, nil
+	}
+
+	return end - start, db.SaveSummary(app.currentSession.ID, summary, end)
+}
+
+func leadingSystemMessages(messages []types.Message) int {
+	system := 0
+	for system < len(messages) && messages[system].Role == "system" {
+		system++
+	}
+
+	return system
+}
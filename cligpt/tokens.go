@@ -0,0 +1,291 @@
+package cligpt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eitamonya/cligpt/types"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	// Tokens kept free for the answer when max_tokens is not set
+	defaultReplyTokens = 1024
+	// Context window of models missing from contextWindows
+	defaultContextWindow = 8192
+	// Every message costs a few tokens on top of its content, and the
+	// answer is primed with a few more
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+	// Rough cost of an image, the exact cost depends on its size
+	tokensPerImage = 765
+)
+
+const (
+	contextStrategyDrop      = "drop"
+	contextStrategySummarize = "summarize"
+	contextStrategyError     = "error"
+)
+
+// Context window sizes, matched by the longest model prefix. context_window in
+// the config overrides them.
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo": 16385,
+	"gpt-4":         8192,
+	"gpt-4-32k":     32768,
+	"gpt-4-turbo":   128000,
+	"gpt-4-1106":    128000,
+	"gpt-4-0125":    128000,
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"gpt-5":         400000,
+	"o1":            200000,
+	"o3":            200000,
+	"o4":            200000,
+	"claude":        200000,
+}
+
+func init() {
+	// Use the encodings bundled with the binary instead of downloading them
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+var encodings = map[string]*tiktoken.Tiktoken{}
+
+// encodingName picks the tokenizer of the model. Models from other vendors
+// are counted with cl100k, which is close enough to size the context.
+func encodingName(model string) string {
+	for _, prefix := range []string{"gpt-4o", "gpt-4.1", "gpt-5", "o1", "o3", "o4"} {
+		if strings.HasPrefix(model, prefix) {
+			return tiktoken.MODEL_O200K_BASE
+		}
+	}
+
+	return tiktoken.MODEL_CL100K_BASE
+}
+
+func getEncoding(model string) (*tiktoken.Tiktoken, error) {
+	name := encodingName(model)
+	if encoding, ok := encodings[name]; ok {
+		return encoding, nil
+	}
+
+	encoding, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the %s tokenizer: %w", name, err)
+	}
+	encodings[name] = encoding
+
+	return encoding, nil
+}
+
+func contextWindow(model string) int {
+	if window, ok := matchPrefix(contextWindows, model); ok {
+		return window
+	}
+
+	return defaultContextWindow
+}
+
+// matchPrefix looks up the model in a table keyed by model prefixes, the
+// longest matching prefix wins so gpt-4o doesn't match gpt-4.
+func matchPrefix[T any](table map[string]T, model string) (T, bool) {
+	var value T
+	longest := -1
+	for prefix, v := range table {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			value = v
+			longest = len(prefix)
+		}
+	}
+
+	return value, longest >= 0
+}
+
+// countTokens estimates the prompt tokens of the messages the way OpenAI
+// counts them for chat models.
+func countTokens(model string, messages []types.Message) (int, error) {
+	counts, err := messageTokens(model, messages)
+	if err != nil {
+		return 0, err
+	}
+
+	return sumTokens(counts), nil
+}
+
+// messageTokens returns the tokens each message adds to the prompt.
+func messageTokens(model string, messages []types.Message) ([]int, error) {
+	encoding, err := getEncoding(model)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(messages))
+	for i, message := range messages {
+		counts[i] = tokensPerMessage +
+			len(encoding.EncodeOrdinary(message.Role)) +
+			len(encoding.EncodeOrdinary(message.Content)) +
+			message.Images()*tokensPerImage
+	}
+
+	return counts, nil
+}
+
+func sumTokens(counts []int) int {
+	tokens := tokensPerReply
+	for _, count := range counts {
+		tokens += count
+	}
+
+	return tokens
+}
+
+func (app *appEnv) contextWindow() int {
+	if app.context_window > 0 {
+		return app.context_window
+	}
+
+	return contextWindow(app.model)
+}
+
+func (app *appEnv) replyTokens() int {
+	if app.max_tokens > 0 {
+		return app.max_tokens
+	}
+
+	return defaultReplyTokens
+}
+
+// remainingContext returns how many tokens the session can still grow by.
+func (app *appEnv) remainingContext() (int, error) {
+	used, err := countTokens(app.model, app.requestMessages())
+	if err != nil {
+		return 0, err
+	}
+
+	return app.contextWindow() - app.replyTokens() - used, nil
+}
+
+// fitContext returns the messages of the current session to send so that
+// they fit in the context window along with the answer. The system messages at
+// the start, like the persona, and the newest message are always kept. How the
+// oldest turns are made room for depends on context_strategy.
+func (app *appEnv) fitContext(ctx context.Context) ([]types.Message, error) {
+	budget := app.contextWindow() - app.replyTokens()
+
+	messages := app.requestMessages()
+	used, err := countTokens(app.model, messages)
+	if err != nil {
+		return nil, err
+	}
+	if used <= budget {
+		return messages, nil
+	}
+
+	if app.context_strategy == contextStrategyError {
+		return nil, fmt.Errorf("%w: the conversation needs %d tokens, %d are available", ErrContextLength, used, budget)
+	}
+
+	if app.context_strategy == contextStrategySummarize {
+		// Leave a quarter of the room for the summary, next to the messages
+		// at the start
+		system := leadingSystemMessages(app.currentSession.Messages)
+		head, err := messageTokens(app.model, app.currentSession.Messages[:system])
+		if err != nil {
+			return nil, err
+		}
+		target := budget * 3 / 4
+
+		summarized, err := app.foldIntoSummary(ctx, target-sumTokens(head), budget-target)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = app.requestMessages()
+		if used, err = countTokens(app.model, messages); err != nil {
+			return nil, err
+		}
+		if used <= budget {
+			fmt.Fprintf(os.Stderr, "Summarized %d old messages to fit the context window\n", summarized)
+			return messages, nil
+		}
+	}
+
+	counts, err := messageTokens(app.model, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	system := leadingSystemMessages(messages)
+	head := messages[:system]
+
+	// Drop the oldest turns until the rest fits, a turn starts with a user
+	// message
+	dropped := 0
+	for system+dropped < len(messages)-1 && (used > budget || messages[system+dropped].Role != "user") {
+		used -= counts[system+dropped]
+		dropped++
+	}
+	if used > budget {
+		return nil, fmt.Errorf("%w: the newest message alone needs %d tokens, %d are available", ErrContextLength, used, budget)
+	}
+
+	fmt.Fprintf(os.Stderr, "Left out %d old messages to fit the context window\n", dropped)
+
+	return append(append([]types.Message{}, head...), messages[system+dropped:]...), nil
+}
+
+// summarize asks the model for a summary of the messages of at most maxTokens.
+func (app *appEnv) summarize(ctx context.Context, messages []types.Message, maxTokens int) (string, error) {
+	var transcript strings.Builder
+	for _, message := range messages {
+		transcript.WriteString(strings.ToUpper(message.Role) + ": " + message.Content + "\n\n")
+	}
+
+	request := CompletionRequest{
+		Model:       app.model,
+		Temperature: 0,
+		MaxTokens:   maxTokens,
+		Messages: []types.Message{
+			createMessage("system", "Summarize the following conversation in a few paragraphs. Keep the facts, decisions, code and open questions that later messages may refer to."),
+			createMessage("user", transcript.String()),
+		},
+	}
+
+	// The transcript itself may be too long, keep its newest part
+	budget := app.contextWindow() - app.replyTokens()
+	for {
+		used, err := countTokens(app.model, request.Messages)
+		if err != nil {
+			return "", err
+		}
+		content := request.Messages[1].Content
+		if used <= budget || len(content) < 4 {
+			break
+		}
+		// Don't start in the middle of a multi-byte character
+		cut := len(content) / 4
+		for cut < len(content) && !utf8.RuneStart(content[cut]) {
+			cut++
+		}
+		request.Messages[1].Content = content[cut:]
+	}
+
+	response, err := app.provider.Complete(ctx, request)
+	if err != nil {
+		return "", fmt.Errorf("error summarizing the conversation: %w", err)
+	}
+	app.recordUsage(request, response)
+
+	return response.Content, nil
+}
+
+// summaryMessage is the system message that stands in for the summarized
+// messages.
+func summaryMessage(summary string) types.Message {
+	return createMessage("system", "Summary of the earlier conversation:\n"+summary)
+}
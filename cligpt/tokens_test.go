@@ -0,0 +1,324 @@
+package cligpt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// useTempHome keeps the database and config of a test in a new home
+// directory.
+func useTempHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	if err := os.MkdirAll(filepath.Join(home, ".cligpt"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	return home
+}
+
+// fakeProvider answers every request with content, or fails with err.
+type fakeProvider struct {
+	content  string
+	err      error
+	requests []CompletionRequest
+}
+
+func (p *fakeProvider) Complete(ctx context.Context, req CompletionRequest) (CompletionResponse, error) {
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return CompletionResponse{}, p.err
+	}
+
+	return CompletionResponse{Content: p.content}, nil
+}
+
+func (p *fakeProvider) Stream(ctx context.Context, req CompletionRequest, onDelta func(string)) (CompletionResponse, error) {
+	response, err := p.Complete(ctx, req)
+	if err == nil {
+		onDelta(response.Content)
+	}
+
+	return response, err
+}
+
+func (p *fakeProvider) ListModels(ctx context.Context) ([]string, error) {
+	return []string{"gpt-4"}, nil
+}
+
+// longSession is a chat of two long turns followed by a short question, each
+// message starts with its name.
+func longSession() types.Session {
+	words := strings.Repeat(" word", 200)
+
+	return types.Session{Messages: []types.Message{
+		createMessage("system", "system be brief"),
+		createMessage("user", "u1"+words),
+		createMessage("assistant", "a1"+words),
+		createMessage("user", "u2"+words),
+		createMessage("assistant", "a2"+words),
+		createMessage("user", "u3 last question"),
+	}}
+}
+
+// names returns the first word of every message.
+func names(messages []types.Message) []string {
+	var names []string
+	for _, message := range messages {
+		names = append(names, strings.Fields(message.Content)[0])
+	}
+
+	return names
+}
+
+func TestFitContext(t *testing.T) {
+	useTempHome(t)
+
+	tests := []struct {
+		name     string
+		strategy string
+		window   int
+		err      error
+		names    []string
+		summary  bool
+	}{
+		{
+			name:     "fits",
+			strategy: contextStrategyError,
+			window:   2000,
+			names:    []string{"system", "u1", "a1", "u2", "a2", "u3"},
+		},
+		{
+			name:     "too long",
+			strategy: contextStrategyError,
+			window:   600,
+			err:      ErrContextLength,
+		},
+		{
+			name:     "drop the oldest turn",
+			strategy: contextStrategyDrop,
+			window:   600,
+			names:    []string{"system", "u2", "a2", "u3"},
+		},
+		{
+			name:     "drop all but the newest message",
+			strategy: contextStrategyDrop,
+			window:   300,
+			names:    []string{"system", "u3"},
+		},
+		{
+			name:     "newest message doesn't fit",
+			strategy: contextStrategyDrop,
+			window:   110,
+			err:      ErrContextLength,
+		},
+		{
+			name:     "summarize",
+			strategy: contextStrategySummarize,
+			window:   600,
+			names:    []string{"system", "Summary", "u3"},
+			summary:  true,
+		},
+		{
+			name:     "summarizing fails",
+			strategy: contextStrategySummarize,
+			window:   600,
+			err:      ErrAPI,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &fakeProvider{content: "they talked about words"}
+			if test.strategy == contextStrategySummarize && test.err != nil {
+				provider.err = test.err
+			}
+			app := &appEnv{
+				model:            "gpt-4",
+				provider:         provider,
+				context_window:   test.window,
+				max_tokens:       100,
+				context_strategy: test.strategy,
+				currentSession:   longSession(),
+			}
+
+			messages, err := app.fitContext(context.Background())
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error = %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := names(messages); !reflect.DeepEqual(got, test.names) {
+				t.Errorf("messages = %q, want %q", got, test.names)
+			}
+			if len(app.currentSession.Messages) != 6 {
+				t.Errorf("the session has %d messages, it should keep all of them", len(app.currentSession.Messages))
+			}
+			if summarized := app.currentSession.Summary != ""; summarized != test.summary {
+				t.Errorf("summary = %q", app.currentSession.Summary)
+			}
+		})
+	}
+}
+
+func TestFoldIntoSummary(t *testing.T) {
+	useTempHome(t)
+
+	counts, err := messageTokens("gpt-4", longSession().Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		keep        int
+		summary     string
+		summaryUpTo int
+		err         error
+		summarized  int
+		transcript  []string
+	}{
+		{
+			name:       "everything fits",
+			keep:       sumTokens(counts),
+			summarized: 0,
+		},
+		{
+			name:       "oldest turn",
+			keep:       sumTokens(counts[3:]),
+			summarized: 2,
+			transcript: []string{"USER: u1", "ASSISTANT: a1"},
+		},
+		{
+			name:       "all but the newest message",
+			keep:       0,
+			summarized: 4,
+			transcript: []string{"USER: u1", "ASSISTANT: a1", "USER: u2", "ASSISTANT: a2"},
+		},
+		{
+			name:       "whole turns only",
+			keep:       sumTokens(counts[4:]),
+			summarized: 4,
+			transcript: []string{"USER: u1", "USER: u2", "ASSISTANT: a2"},
+		},
+		{
+			name:        "onto the summary so far",
+			keep:        0,
+			summary:     "earlier words",
+			summaryUpTo: 3,
+			summarized:  2,
+			transcript:  []string{"SYSTEM: Summary of the earlier conversation:\nearlier words", "USER: u2", "ASSISTANT: a2"},
+		},
+		{
+			name: "summarizing fails",
+			keep: 0,
+			err:  ErrRateLimited,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &fakeProvider{content: "new summary", err: test.err}
+			session := longSession()
+			session.Summary = test.summary
+			session.SummaryUpTo = test.summaryUpTo
+			app := &appEnv{model: "gpt-4", provider: provider, currentSession: session}
+
+			summarized, err := app.foldIntoSummary(context.Background(), test.keep, 50)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("error = %v, want %v", err, test.err)
+				}
+				if app.currentSession.Summary != test.summary {
+					t.Errorf("summary = %q, a failed summary should leave it as it was", app.currentSession.Summary)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if summarized != test.summarized {
+				t.Errorf("summarized %d messages, want %d", summarized, test.summarized)
+			}
+			if summarized == 0 {
+				if len(provider.requests) != 0 {
+					t.Error("the model was asked for a summary of nothing")
+				}
+				return
+			}
+
+			if app.currentSession.Summary != "new summary" {
+				t.Errorf("summary = %q", app.currentSession.Summary)
+			}
+			start := test.summaryUpTo
+			if start == 0 {
+				start = 1
+			}
+			if app.currentSession.SummaryUpTo != start+summarized {
+				t.Errorf("summary up to %d, want %d", app.currentSession.SummaryUpTo, start+summarized)
+			}
+
+			request := provider.requests[0]
+			if request.MaxTokens != 50 {
+				t.Errorf("max tokens = %d, want 50", request.MaxTokens)
+			}
+			transcript := request.Messages[1].Content
+			for _, part := range test.transcript {
+				if !strings.Contains(transcript, part) {
+					t.Errorf("the transcript is missing %q", part)
+				}
+			}
+			if strings.Contains(transcript, "u3") {
+				t.Error("the newest message was summarized")
+			}
+		})
+	}
+}
+
+// A transcript over the context window is cut from the start, on a character
+// boundary.
+func TestSummarizeLongTranscript(t *testing.T) {
+	useTempHome(t)
+
+	// Shift the characters so that some cut falls inside one
+	for shift := 0; shift < utf8.UTFMax; shift++ {
+		provider := &fakeProvider{content: "summary"}
+		app := &appEnv{model: "gpt-4", provider: provider, context_window: 400, max_tokens: 100}
+
+		messages := []types.Message{
+			createMessage("user", strings.Repeat("a", shift)+strings.Repeat("🙂", 1000)),
+			createMessage("assistant", "the end"),
+		}
+		if _, err := app.summarize(context.Background(), messages, 100); err != nil {
+			t.Fatal(err)
+		}
+
+		transcript := provider.requests[0].Messages[1].Content
+		if !utf8.ValidString(transcript) {
+			t.Errorf("shifted by %d: the transcript was cut in the middle of a character", shift)
+		}
+		if !strings.HasSuffix(transcript, "ASSISTANT: the end\n\n") {
+			t.Errorf("shifted by %d: the newest part of the transcript was cut off", shift)
+		}
+		if len(transcript) >= 4000 {
+			t.Errorf("shifted by %d: the transcript wasn't cut", shift)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package cligpt
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eitamonya/cligpt/db"
+	"github.com/eitamonya/cligpt/types"
+)
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// Prices of the models, matched by the longest model prefix. prices in the
+// config add to and override them. Models without a price, like local ones,
+// cost nothing.
+var prices = map[string]Price{
+	"gpt-3.5-turbo":     {Prompt: 0.5, Completion: 1.5},
+	"gpt-4":             {Prompt: 30, Completion: 60},
+	"gpt-4-32k":         {Prompt: 60, Completion: 120},
+	"gpt-4-turbo":       {Prompt: 10, Completion: 30},
+	"gpt-4-1106":        {Prompt: 10, Completion: 30},
+	"gpt-4-0125":        {Prompt: 10, Completion: 30},
+	"gpt-4o":            {Prompt: 2.5, Completion: 10},
+	"gpt-4o-mini":       {Prompt: 0.15, Completion: 0.6},
+	"gpt-4.1":           {Prompt: 2, Completion: 8},
+	"gpt-4.1-mini":      {Prompt: 0.4, Completion: 1.6},
+	"gpt-4.1-nano":      {Prompt: 0.1, Completion: 0.4},
+	"gpt-5":             {Prompt: 1.25, Completion: 10},
+	"gpt-5-mini":        {Prompt: 0.25, Completion: 2},
+	"gpt-5-nano":        {Prompt: 0.05, Completion: 0.4},
+	"o1":                {Prompt: 15, Completion: 60},
+	"o1-mini":           {Prompt: 1.1, Completion: 4.4},
+	"o3":                {Prompt: 2, Completion: 8},
+	"o3-mini":           {Prompt: 1.1, Completion: 4.4},
+	"o4-mini":           {Prompt: 1.1, Completion: 4.4},
+	"claude-3-haiku":    {Prompt: 0.25, Completion: 1.25},
+	"claude-3-5-haiku":  {Prompt: 0.8, Completion: 4},
+	"claude-haiku-4":    {Prompt: 1, Completion: 5},
+	"claude-3-5-sonnet": {Prompt: 3, Completion: 15},
+	"claude-3-7-sonnet": {Prompt: 3, Completion: 15},
+	"claude-sonnet-4":   {Prompt: 3, Completion: 15},
+	"claude-3-opus":     {Prompt: 15, Completion: 75},
+	"claude-opus-4":     {Prompt: 15, Completion: 75},
+	"claude-opus-4-1":   {Prompt: 15, Completion: 75},
+	"claude-opus-4-5":   {Prompt: 5, Completion: 25},
+}
+
+// Prices of a generated image in USD, keyed by model, quality and size.
+// image_prices in the config add to and override them.
+var imagePrices = map[string]float64{
+	"dall-e-2 standard 256x256":    0.016,
+	"dall-e-2 standard 512x512":    0.018,
+	"dall-e-2 standard 1024x1024":  0.02,
+	"dall-e-3 standard 1024x1024":  0.04,
+	"dall-e-3 standard 1024x1792":  0.08,
+	"dall-e-3 standard 1792x1024":  0.08,
+	"dall-e-3 hd 1024x1024":        0.08,
+	"dall-e-3 hd 1024x1792":        0.12,
+	"dall-e-3 hd 1792x1024":        0.12,
+	"gpt-image-1 low 1024x1024":    0.011,
+	"gpt-image-1 low 1024x1536":    0.016,
+	"gpt-image-1 low 1536x1024":    0.016,
+	"gpt-image-1 medium 1024x1024": 0.042,
+	"gpt-image-1 medium 1024x1536": 0.063,
+	"gpt-image-1 medium 1536x1024": 0.063,
+	"gpt-image-1 high 1024x1024":   0.167,
+	"gpt-image-1 high 1024x1536":   0.25,
+	"gpt-image-1 high 1536x1024":   0.25,
+}
+
+func (app *appEnv) imagePrice(image Image) float64 {
+	quality := image.Quality
+	if quality == "" {
+		quality = "standard"
+	}
+	key := image.Model + " " + quality + " " + image.Size
+
+	if price, ok := app.image_prices[key]; ok {
+		return price
+	}
+
+	return imagePrices[key]
+}
+
+func (app *appEnv) price(model string) Price {
+	table := make(map[string]Price, len(prices)+len(app.prices))
+	for prefix, price := range prices {
+		table[prefix] = price
+	}
+	for prefix, price := range app.prices {
+		table[prefix] = price
+	}
+
+	price, _ := matchPrefix(table, model)
+	return price
+}
+
+func (price Price) cost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
+
+// recordUsage saves the usage of a request with the current session.
+func (app *appEnv) recordUsage(request CompletionRequest, response CompletionResponse) {
+	app.saveUsage(request.Model, app.responseUsage(request, response))
+}
+
+// responseUsage returns the usage reported by the API. When there is none,
+// e.g. because the stream was cancelled, it is counted locally.
+func (app *appEnv) responseUsage(request CompletionRequest, response CompletionResponse) Usage {
+	if response.Usage != (Usage{}) || response.Content == "" {
+		return response.Usage
+	}
+
+	usage, err := estimateUsage(request, response.Content)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: usage not counted:", err)
+	}
+
+	return usage
+}
+
+func (app *appEnv) saveUsage(model string, usage Usage) {
+	if usage == (Usage{}) {
+		return
+	}
+
+	err := db.AddUsage(types.UsageRecord{
+		SessionID:        app.currentSession.ID,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             app.price(model).cost(usage),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: usage not recorded:", err)
+	}
+}
+
+// recordImage saves the cost of a generated image.
+func (app *appEnv) recordImage(image Image) {
+	err := db.AddUsage(types.UsageRecord{
+		Model:  image.Model,
+		Images: 1,
+		Cost:   app.imagePrice(image),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: usage not recorded:", err)
+	}
+}
+
+func estimateUsage(request CompletionRequest, content string) (Usage, error) {
+	prompt, err := countTokens(request.Model, request.Messages)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	encoding, err := getEncoding(request.Model)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return Usage{PromptTokens: prompt, CompletionTokens: len(encoding.EncodeOrdinary(content))}, nil
+}
+
+const (
+	usageByModel   = "model"
+	usageByDay     = "day"
+	usageBySession = "session"
+)
+
+// UsageRow is a line of the usage report.
+type UsageRow struct {
+	Key              string  `json:"key"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Images           int     `json:"images"`
+	Cost             float64 `json:"cost"`
+}
+
+var relativeSince = regexp.MustCompile(`^(\d+)([hdwm])$`)
+
+// parseSince accepts a date, a date and time, or a period back from now like
+// 12h, 7d, 4w or 3m.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+
+	if match := relativeSince.FindStringSubmatch(since); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		switch match[2] {
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "d":
+			return now.AddDate(0, 0, -n), nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), nil
+		case "m":
+			return now.AddDate(0, -n, 0), nil
+		}
+	}
+
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, since, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid --since %q, use a date like 2024-01-31 or a period like 7d", since)
+}
+
+func usageKey(record types.UsageRecord, by string) string {
+	switch by {
+	case usageByDay:
+		return record.CreatedAt.Local().Format("2006-01-02")
+	case usageBySession:
+		if record.SessionID == 0 {
+			return "none"
+		}
+		return strconv.Itoa(record.SessionID)
+	}
+
+	return record.Model
+}
+
+func groupUsage(records []types.UsageRecord, by string) []UsageRow {
+	var rows []UsageRow
+	index := map[string]int{}
+	for _, record := range records {
+		key := usageKey(record, by)
+		i, ok := index[key]
+		if !ok {
+			i = len(rows)
+			index[key] = i
+			rows = append(rows, UsageRow{Key: key})
+		}
+
+		rows[i].Requests++
+		rows[i].PromptTokens += record.PromptTokens
+		rows[i].CompletionTokens += record.CompletionTokens
+		rows[i].Images += record.Images
+		rows[i].Cost += record.Cost
+	}
+
+	// Costs are summed in floating point, round off the noise
+	for i := range rows {
+		rows[i].Cost = math.Round(rows[i].Cost*1e6) / 1e6
+	}
+
+	// Days in order, the rest by cost
+	if by != usageByDay {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Cost > rows[j].Cost })
+	}
+
+	return rows
+}
+
+// PrintUsage prints the tokens used and what they cost since the given time,
+// grouped by model, day or session, as a table, CSV or JSON.
+func PrintUsage(since string, by string, format string) error {
+	if by != usageByModel && by != usageByDay && by != usageBySession {
+		return fmt.Errorf("invalid --by %q, use model, day or session", by)
+	}
+
+	from, err := parseSince(since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	records, err := db.GetUsage(from)
+	if err != nil {
+		return err
+	}
+
+	rows := groupUsage(records, by)
+
+	switch format {
+	case "json":
+		output, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{by, "requests", "prompt_tokens", "completion_tokens", "images", "cost"})
+		for _, row := range rows {
+			w.Write([]string{
+				row.Key,
+				strconv.Itoa(row.Requests),
+				strconv.Itoa(row.PromptTokens),
+				strconv.Itoa(row.CompletionTokens),
+				strconv.Itoa(row.Images),
+				strconv.FormatFloat(row.Cost, 'f', 6, 64),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No usage recorded")
+		return nil
+	}
+
+	var total UsageRow
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(by)+"\tREQUESTS\tPROMPT\tCOMPLETION\tIMAGES\tCOST")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t$%.4f\n", row.Key, row.Requests, row.PromptTokens, row.CompletionTokens, row.Images, row.Cost)
+		total.Requests += row.Requests
+		total.PromptTokens += row.PromptTokens
+		total.CompletionTokens += row.CompletionTokens
+		total.Images += row.Images
+		total.Cost += row.Cost
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t%d\t$%.4f\n", total.Requests, total.PromptTokens, total.CompletionTokens, total.Images, total.Cost)
+
+	return w.Flush()
+}
@@ -0,0 +1,145 @@
+package cligpt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 31, 15, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		since string
+		want  time.Time
+		err   bool
+	}{
+		{"", time.Time{}, false},
+		{"12h", now.Add(-12 * time.Hour), false},
+		{"7d", time.Date(2024, 3, 24, 15, 30, 0, 0, time.Local), false},
+		{"2w", time.Date(2024, 3, 17, 15, 30, 0, 0, time.Local), false},
+		// February has no 31st, AddDate rolls over into March
+		{"1m", time.Date(2024, 3, 2, 15, 30, 0, 0, time.Local), false},
+		{"0d", now, false},
+		{"2024-01-31", time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local), false},
+		{"2024-01-31 08:15", time.Date(2024, 1, 31, 8, 15, 0, 0, time.Local), false},
+		{"2024-01-31T08:15:00Z", time.Date(2024, 1, 31, 8, 15, 0, 0, time.UTC), false},
+		{"7", time.Time{}, true},
+		{"d7", time.Time{}, true},
+		{"7y", time.Time{}, true},
+		{"-7d", time.Time{}, true},
+		{"31/01/2024", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseSince(test.since, now)
+		if (err != nil) != test.err {
+			t.Errorf("%q: error = %v, want an error: %v", test.since, err, test.err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%q = %s, want %s", test.since, got, test.want)
+		}
+	}
+}
+
+func TestGroupUsage(t *testing.T) {
+	day := func(d int, hour int) time.Time {
+		return time.Date(2024, 3, d, hour, 0, 0, 0, time.Local)
+	}
+	records := []types.UsageRecord{
+		{SessionID: 1, Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 10, Cost: 0.1, CreatedAt: day(1, 9)},
+		{SessionID: 1, Model: "gpt-4o", PromptTokens: 200, CompletionTokens: 20, Cost: 0.2, CreatedAt: day(1, 23)},
+		{SessionID: 2, Model: "claude-sonnet-4", PromptTokens: 300, CompletionTokens: 30, Cost: 0.7, CreatedAt: day(2, 8)},
+		{Model: "dall-e-3", Images: 2, Cost: 0.08, CreatedAt: day(3, 12)},
+		{SessionID: 2, Model: "llama3", PromptTokens: 50, CompletionTokens: 5, CreatedAt: day(3, 13)},
+	}
+
+	tests := []struct {
+		by   string
+		rows []UsageRow
+	}{
+		{
+			by: usageByModel,
+			rows: []UsageRow{
+				{Key: "claude-sonnet-4", Requests: 1, PromptTokens: 300, CompletionTokens: 30, Cost: 0.7},
+				// 0.1 + 0.2 is rounded to 0.3
+				{Key: "gpt-4o", Requests: 2, PromptTokens: 300, CompletionTokens: 30, Cost: 0.3},
+				{Key: "dall-e-3", Requests: 1, Images: 2, Cost: 0.08},
+				{Key: "llama3", Requests: 1, PromptTokens: 50, CompletionTokens: 5},
+			},
+		},
+		{
+			by: usageByDay,
+			rows: []UsageRow{
+				{Key: "2024-03-01", Requests: 2, PromptTokens: 300, CompletionTokens: 30, Cost: 0.3},
+				{Key: "2024-03-02", Requests: 1, PromptTokens: 300, CompletionTokens: 30, Cost: 0.7},
+				{Key: "2024-03-03", Requests: 2, PromptTokens: 50, CompletionTokens: 5, Images: 2, Cost: 0.08},
+			},
+		},
+		{
+			by: usageBySession,
+			rows: []UsageRow{
+				{Key: "2", Requests: 2, PromptTokens: 350, CompletionTokens: 35, Cost: 0.7},
+				{Key: "1", Requests: 2, PromptTokens: 300, CompletionTokens: 30, Cost: 0.3},
+				{Key: "none", Requests: 1, Images: 2, Cost: 0.08},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.by, func(t *testing.T) {
+			rows := groupUsage(records, test.by)
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("rows = %+v\nwant %+v", rows, test.rows)
+			}
+		})
+	}
+
+	if rows := groupUsage(nil, usageByModel); len(rows) != 0 {
+		t.Errorf("rows = %+v without records", rows)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	app := &appEnv{prices: map[string]Price{
+		"gpt-4o":   {Prompt: 2, Completion: 8},
+		"my-local": {Prompt: 1, Completion: 1},
+	}}
+
+	tests := []struct {
+		model string
+		price Price
+	}{
+		{"gpt-4", Price{Prompt: 30, Completion: 60}},
+		{"gpt-4-0613", Price{Prompt: 30, Completion: 60}},
+		{"gpt-4-32k-0613", Price{Prompt: 60, Completion: 120}},
+		{"gpt-4-turbo-2024-04-09", Price{Prompt: 10, Completion: 30}},
+		// The config overrides the built-in price
+		{"gpt-4o-2024-08-06", Price{Prompt: 2, Completion: 8}},
+		// A longer built-in prefix still wins over a config entry
+		{"gpt-4o-mini", Price{Prompt: 0.15, Completion: 0.6}},
+		{"claude-opus-4-1-20250805", Price{Prompt: 15, Completion: 75}},
+		{"claude-opus-4-5", Price{Prompt: 5, Completion: 25}},
+		{"claude-opus-4-20250514", Price{Prompt: 15, Completion: 75}},
+		{"my-local-model", Price{Prompt: 1, Completion: 1}},
+		{"llama3", Price{}},
+	}
+
+	for _, test := range tests {
+		if price := app.price(test.model); price != test.price {
+			t.Errorf("%s: price = %+v, want %+v", test.model, price, test.price)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	price := Price{Prompt: 2.5, Completion: 10}
+	cost := price.cost(Usage{PromptTokens: 1000, CompletionTokens: 500})
+	if cost != 0.0075 {
+		t.Errorf("cost = %v, want 0.0075", cost)
+	}
+}
@@ -14,7 +14,7 @@ var chatCmd = &cobra.Command{
 	Use:   "chat",
 	Short: "Start a chat with the model",
 	Long:  `This command will start a chat with the model, you can specify the initial prompt to use with the --prompt flag`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		prompt, _ := cmd.Flags().GetString("prompt")
 		if prompt == "" && len(args) > 0 {
 			for _, arg := range args {
@@ -22,9 +22,19 @@ var chatCmd = &cobra.Command{
 			}
 		}
 
-		app := cligpt.InitApp()
+		app, err := cligpt.InitApp()
+		if err != nil {
+			return err
+		}
+		app.BaseURL, _ = cmd.Flags().GetString("base-url")
 		app.InitialPrompt = prompt
-		app.Chat()
+		app.Files, _ = cmd.Flags().GetStringArray("file")
+		app.Images, _ = cmd.Flags().GetStringArray("image")
+		app.Force, _ = cmd.Flags().GetBool("force")
+		if err := app.ReadStdin(); err != nil {
+			return err
+		}
+		return app.Chat()
 	},
 }
 
@@ -32,17 +42,27 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List the saved chat sessions",
 	Long:  `This command will list the saved chat sessions`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		prompt, _ := cmd.Flags().GetString("prompt")
-		app := cligpt.InitApp()
+		app, err := cligpt.InitApp()
+		if err != nil {
+			return err
+		}
+		app.BaseURL, _ = cmd.Flags().GetString("base-url")
 		app.InitialPrompt = prompt
-		app.ListAndSelectSession()
-		app.Chat()
+		app.Force, _ = cmd.Flags().GetBool("force")
+		if err := app.ListAndSelectSession(); err != nil {
+			return err
+		}
+		return app.Chat()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(chatCmd)
 	chatCmd.Flags().StringP("prompt", "p", "", "The initial prompt to use for the chat session\nUsage: --prompt \"Hello, how are you?\"")
+	chatCmd.Flags().StringArrayP("file", "f", nil, "Attach a file to the first message, can be repeated and accepts globs\nUsage: -f main.go -f 'internal/**/*.go'")
+	chatCmd.Flags().StringArray("image", nil, "Send a PNG, JPEG, GIF or WebP image with the first message, can be repeated\nUsage: --image screenshot.png")
+	chatCmd.PersistentFlags().Bool("force", false, "Keep chatting even if the budget is spent")
 	chatCmd.AddCommand(listCmd)
 }
@@ -17,19 +17,26 @@ var imageCmd = &cobra.Command{
 	cligpt image [prompt]
 
 	Generate a DALL-E image using the OpenAI API.
-	Please note that this is charged on different basis compared to the ChatGPT/GPT-4 API.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Please note that this is charged on different basis compared to the ChatGPT/GPT-4 API.
+	Every image is counted against the budget at the price of its model, size and quality.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var prompt string
 		for _, arg := range args {
 			prompt += arg + " "
 		}
 
-		app := cligpt.InitApp()
+		app, err := cligpt.InitApp()
+		if err != nil {
+			return err
+		}
+		app.BaseURL, _ = cmd.Flags().GetString("base-url")
 		app.InitialPrompt = prompt
-		app.GenerateImage()
+		app.Force, _ = cmd.Flags().GetBool("force")
+		return app.GenerateImage()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(imageCmd)
+	imageCmd.Flags().Bool("force", false, "Generate the image even if it goes over the budget")
 }
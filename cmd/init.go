@@ -10,8 +10,8 @@ var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initiate the setup for cli-gpt",
 	Long:  `This command will initiate the setup for cli-gpt`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.Init()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.Init()
 	},
 }
 
@@ -15,8 +15,8 @@ var maxtCmd = &cobra.Command{
 	Use:   "maxt",
 	Short: "Set max token usage",
 	Long:  `This command will set the max token usage`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.SetMaxTokens()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.SetMaxTokens()
 	},
 }
 
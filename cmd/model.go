@@ -15,11 +15,26 @@ var modelCmd = &cobra.Command{
 	Use:   "model",
 	Short: "Change the model configuration",
 	Long:  `This command will change the model configuration`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.SelectAndSaveModel()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.SelectAndSaveModel()
+	},
+}
+
+var listModelsCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the models available to you",
+	Long:  `This command will list the models available from the configured provider`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app, err := cligpt.InitApp()
+		if err != nil {
+			return err
+		}
+		app.BaseURL, _ = cmd.Flags().GetString("base-url")
+		return app.ListModels()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(modelCmd)
+	modelCmd.AddCommand(listModelsCmd)
 }
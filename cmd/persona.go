@@ -15,8 +15,8 @@ var personaCmd = &cobra.Command{
 	Use:   "persona",
 	Short: "Set active persona",
 	Long:  `This command will set the active persona`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.SetActivePersonality()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.SetActivePersonality()
 	},
 }
 
@@ -24,8 +24,8 @@ var addPersonaCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a new persona",
 	Long:  `This command will add a new persona`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.AddPersonality()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.AddPersonality()
 	},
 }
 
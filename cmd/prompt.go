@@ -5,6 +5,8 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"os"
+
 	"github.com/eitamonya/cligpt/cligpt"
 
 	"github.com/spf13/cobra"
@@ -15,21 +17,42 @@ var promptCmd = &cobra.Command{
 	Use:   "prompt",
 	Short: "Prompt the model with a single prompt",
 	Long:  `This command will prompt the model with a single prompt`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var prompt string
 		for _, arg := range args {
 			prompt += arg + " "
 		}
 
 		isJson, _ := cmd.Flags().GetBool("json")
-		app := cligpt.InitApp()
+		stream, _ := cmd.Flags().GetBool("stream")
+		// --json keeps printing the whole response unless --stream is given
+		if !cmd.Flags().Changed("stream") {
+			stream = !isJson && cligpt.IsTerminal(os.Stdout)
+		}
+
+		app, err := cligpt.InitApp()
+		if err != nil {
+			return err
+		}
+		app.BaseURL, _ = cmd.Flags().GetString("base-url")
 		app.InitialPrompt = prompt
+		app.Files, _ = cmd.Flags().GetStringArray("file")
+		app.Images, _ = cmd.Flags().GetStringArray("image")
+		if err := app.ReadStdin(); err != nil {
+			return err
+		}
 		app.OutputJSON = isJson
-		app.SinglePrompt()
+		app.Force, _ = cmd.Flags().GetBool("force")
+		app.Stream = stream
+		return app.SinglePrompt()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(promptCmd)
 	promptCmd.Flags().BoolP("json", "j", false, "Use this flag if you want the response to be output in json")
+	promptCmd.Flags().StringArrayP("file", "f", nil, "Attach a file to the prompt, can be repeated and accepts globs\nUsage: -f main.go -f 'internal/**/*.go'")
+	promptCmd.Flags().StringArray("image", nil, "Send a PNG, JPEG, GIF or WebP image with the prompt, can be repeated\nUsage: --image screenshot.png")
+	promptCmd.Flags().Bool("force", false, "Send the prompt even if the budget is spent")
+	promptCmd.Flags().BoolP("stream", "s", false, "Print the response as it is generated, with --json as one JSON object per line\nDefaults to true when the output is a terminal, unless --json is used")
 }
@@ -5,29 +5,107 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 
+	"github.com/eitamonya/cligpt/cligpt"
+	"github.com/eitamonya/cligpt/db"
+
 	"github.com/spf13/cobra"
 )
 
+// Exit codes, so scripts can tell the failures apart
+const (
+	exitError       = 1
+	exitConfig      = 3
+	exitAuth        = 4
+	exitRateLimited = 5
+	exitNetwork     = 6
+	exitAPI         = 7
+	exitNoSessions  = 8
+	exitUnsupported = 9
+	exitContext     = 10
+	exitBudget      = 11
+	exitAborted     = 130
+)
+
+type exitStatus struct {
+	code int
+	name string
+}
+
+// errorExitStatus maps the errors returned by the cligpt and db packages to
+// an exit code and a short name used in the JSON output.
+func errorExitStatus(err error) exitStatus {
+	switch {
+	case errors.Is(err, cligpt.ErrNoConfig):
+		return exitStatus{exitConfig, "no_config"}
+	case errors.Is(err, cligpt.ErrConfig):
+		return exitStatus{exitConfig, "config"}
+	case errors.Is(err, cligpt.ErrAuth):
+		return exitStatus{exitAuth, "auth"}
+	case errors.Is(err, cligpt.ErrRateLimited):
+		return exitStatus{exitRateLimited, "rate_limited"}
+	case errors.Is(err, cligpt.ErrContextLength):
+		return exitStatus{exitContext, "context_length"}
+	case errors.Is(err, cligpt.ErrNetwork):
+		return exitStatus{exitNetwork, "network"}
+	case errors.Is(err, cligpt.ErrAPI):
+		return exitStatus{exitAPI, "api"}
+	case errors.Is(err, db.ErrNoSessions), errors.Is(err, db.ErrSessionNotFound):
+		return exitStatus{exitNoSessions, "no_sessions"}
+	case errors.Is(err, cligpt.ErrUnsupported):
+		return exitStatus{exitUnsupported, "unsupported"}
+	case errors.Is(err, cligpt.ErrBudget):
+		return exitStatus{exitBudget, "budget"}
+	case errors.Is(err, cligpt.ErrAborted):
+		return exitStatus{exitAborted, "aborted"}
+	}
+
+	return exitStatus{exitError, "error"}
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "cligpt",
 	Short: "A CLI application for interacting with OpenAI's GPT APIs",
 	Long:  `A CLI application for interacting with OpenAI's GPT APIs`,
+	// Errors are printed by Execute
+	SilenceErrors: true,
+	SilenceUsage:  true,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
-	// Run: func(cmd *cobra.Command, args []string) { },
+	// RunE: func(cmd *cobra.Command, args []string) error { },
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
-	if err != nil {
-		os.Exit(1)
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		return
+	}
+
+	status := errorExitStatus(err)
+
+	if isJson, _ := cmd.Flags().GetBool("json"); isJson {
+		output, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{
+				"type":      status.name,
+				"message":   err.Error(),
+				"exit_code": status.code,
+			},
+		})
+		fmt.Fprintln(os.Stderr, string(output))
+	} else {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 	}
+
+	os.Exit(status.code)
 }
 
 func init() {
+	rootCmd.PersistentFlags().String("base-url", "", "The base URL of an OpenAI-compatible API, overrides base_url from the config\nUsage: --base-url \"http://localhost:11434/v1\"")
 }
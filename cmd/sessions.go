@@ -0,0 +1,206 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eitamonya/cligpt/cligpt"
+
+	"github.com/spf13/cobra"
+)
+
+// sessionsCmd represents the sessions command
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage the saved chat sessions",
+	Long:  `This command groups the subcommands that list, show, delete, rename, fork, export, import and resume the saved chat sessions`,
+}
+
+var sessionsLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the saved chat sessions",
+	Long:  `This command will list the saved chat sessions, most recently updated first`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		page, _ := cmd.Flags().GetInt("page")
+		limit, _ := cmd.Flags().GetInt("limit")
+		isJson, _ := cmd.Flags().GetBool("json")
+		return cligpt.ListSessions(page, limit, isJson)
+	},
+}
+
+var sessionsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a saved chat session",
+	Long:  `This command will print the messages of a saved chat session`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseSessionID(args[0])
+		if err != nil {
+			return err
+		}
+		isJson, _ := cmd.Flags().GetBool("json")
+		return cligpt.ShowSession(id, isJson)
+	},
+}
+
+var sessionsRmCmd = &cobra.Command{
+	Use:   "rm <id>...",
+	Short: "Delete saved chat sessions",
+	Long:  `This command will delete the given chat sessions, nothing is deleted if any of them doesn't exist`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var ids []int
+		for _, arg := range args {
+			id, err := parseSessionID(arg)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return cligpt.RemoveSessions(ids)
+	},
+}
+
+var sessionsRenameCmd = &cobra.Command{
+	Use:   "rename <id> <title>",
+	Short: "Rename a saved chat session",
+	Long:  `This command will set the title a chat session is listed under, an empty title goes back to the first message`,
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseSessionID(args[0])
+		if err != nil {
+			return err
+		}
+		return cligpt.RenameSession(id, strings.Join(args[1:], " "))
+	},
+}
+
+var sessionsResumeCmd = &cobra.Command{
+	Use:   "resume <id>",
+	Short: "Continue a saved chat session",
+	Long:  `This command will print a saved chat session and continue the chat`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseSessionID(args[0])
+		if err != nil {
+			return err
+		}
+
+		app, err := cligpt.InitApp()
+		if err != nil {
+			return err
+		}
+		app.BaseURL, _ = cmd.Flags().GetString("base-url")
+		app.Force, _ = cmd.Flags().GetBool("force")
+		return app.ResumeSession(id)
+	},
+}
+
+var sessionsSearchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search the messages of all chat sessions",
+	Long: `This command will search the messages of all chat sessions for the words of the query, best matches first.
+A word ending in * matches any word starting with it.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := cligpt.SearchOptions{Query: strings.Join(args, " ")}
+		options.Role, _ = cmd.Flags().GetString("role")
+		options.Model, _ = cmd.Flags().GetString("model")
+		options.Since, _ = cmd.Flags().GetString("since")
+		options.Until, _ = cmd.Flags().GetString("until")
+		options.Limit, _ = cmd.Flags().GetInt("limit")
+		options.JSON, _ = cmd.Flags().GetBool("json")
+		return cligpt.SearchMessages(options)
+	},
+}
+
+var sessionsForkCmd = &cobra.Command{
+	Use:   "fork <id>",
+	Short: "Fork a saved chat session",
+	Long: `This command will save a new session that shares the turns of a chat session up to --at, all of them by default.
+The fork can then be resumed and goes its own way, the session it was made from is left as it is.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseSessionID(args[0])
+		if err != nil {
+			return err
+		}
+		at, _ := cmd.Flags().GetInt("at")
+		if at < 0 {
+			return fmt.Errorf("invalid --at %d, turns are numbered from 1", at)
+		}
+		return cligpt.ForkSession(id, at)
+	},
+}
+
+var sessionsExportCmd = &cobra.Command{
+	Use:   "export <id>... | --all",
+	Short: "Export chat sessions",
+	Long: `This command will export chat sessions as Markdown or HTML transcripts, as JSON, or as JSONL in the chat fine-tuning format.
+The sessions are written to stdout, or with --out to a file per session in a directory.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := cligpt.ExportOptions{}
+		options.All, _ = cmd.Flags().GetBool("all")
+		options.Format, _ = cmd.Flags().GetString("format")
+		options.Out, _ = cmd.Flags().GetString("out")
+
+		if options.All == (len(args) > 0) {
+			return fmt.Errorf("give the ids of the sessions to export, or --all")
+		}
+		for _, arg := range args {
+			id, err := parseSessionID(arg)
+			if err != nil {
+				return err
+			}
+			options.IDs = append(options.IDs, id)
+		}
+		return cligpt.ExportSessions(options)
+	},
+}
+
+var sessionsImportCmd = &cobra.Command{
+	Use:   "import <file>...",
+	Short: "Import chat sessions from other tools",
+	Long: `This command will save the conversations of ChatGPT data exports (conversations.json), of sessions export --format json
+and of JSONL files with a {"messages":[...]} conversation per line as sessions. Use - to read from stdin.
+Conversations that are already saved are skipped.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.ImportSessions(args)
+	},
+}
+
+func parseSessionID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid session id %q", arg)
+	}
+
+	return id, nil
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsLsCmd, sessionsShowCmd, sessionsRmCmd, sessionsRenameCmd, sessionsResumeCmd, sessionsSearchCmd, sessionsForkCmd, sessionsExportCmd, sessionsImportCmd)
+	sessionsLsCmd.Flags().Int("limit", 20, "The number of sessions per page")
+	sessionsLsCmd.Flags().Int("page", 1, "The page of sessions to list")
+	sessionsLsCmd.Flags().BoolP("json", "j", false, "Output the sessions as JSON")
+	sessionsShowCmd.Flags().BoolP("json", "j", false, "Output the session as JSON")
+	sessionsSearchCmd.Flags().String("role", "", "Only search messages of a role: user, assistant or system")
+	sessionsSearchCmd.Flags().String("model", "", "Only search answers of models starting with this name")
+	sessionsSearchCmd.Flags().String("since", "", "Only search messages since a date or a period back from now\nUsage: --since 2024-01-31 or --since 3w")
+	sessionsSearchCmd.Flags().String("until", "", "Only search messages up to a date, included, or a period back from now")
+	sessionsSearchCmd.Flags().Int("limit", 20, "The maximum number of messages to show")
+	sessionsSearchCmd.Flags().BoolP("json", "j", false, "Output the messages as JSON")
+	sessionsForkCmd.Flags().Int("at", 0, "The last turn the fork shares, all of them by default")
+	sessionsExportCmd.Flags().Bool("all", false, "Export all the sessions")
+	sessionsExportCmd.Flags().String("format", "md", "The format to export to: md, json, html or jsonl")
+	sessionsExportCmd.Flags().String("out", "", "The directory to write a file per session to, instead of stdout")
+	sessionsResumeCmd.Flags().Bool("force", false, "Keep chatting even if the budget is spent")
+}
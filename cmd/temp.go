@@ -15,8 +15,8 @@ var tempCmd = &cobra.Command{
 	Use:   "temp",
 	Short: "Set temperature",
 	Long:  `This command will set the temperature`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.SetTemperature()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.SetTemperature()
 	},
 }
 
@@ -15,8 +15,8 @@ var tokenCmd = &cobra.Command{
 	Use:   "token",
 	Short: "Update the token",
 	Long:  `This command will update the token`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cligpt.GetAndSaveToken()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return cligpt.GetAndSaveToken()
 	},
 }
 
@@ -0,0 +1,42 @@
+/*
+Copyright © 2023 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+	"github.com/eitamonya/cligpt/cligpt"
+
+	"github.com/spf13/cobra"
+)
+
+// usageCmd represents the usage command
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show the tokens used and what they cost",
+	Long:  `This command will show the tokens used and their cost, grouped by model, day or session`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, _ := cmd.Flags().GetString("since")
+		by, _ := cmd.Flags().GetString("by")
+		isJson, _ := cmd.Flags().GetBool("json")
+		isCsv, _ := cmd.Flags().GetBool("csv")
+
+		format := "table"
+		if isJson {
+			format = "json"
+		} else if isCsv {
+			format = "csv"
+		}
+
+		return cligpt.PrintUsage(since, by, format)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+	usageCmd.Flags().String("since", "", "Only count usage since a date or a period back from now\nUsage: --since 2024-01-31 or --since 7d")
+	usageCmd.Flags().String("by", "model", "Group the usage by model, day or session")
+	usageCmd.Flags().BoolP("json", "j", false, "Output the usage as JSON")
+	usageCmd.Flags().Bool("csv", false, "Output the usage as CSV")
+	usageCmd.MarkFlagsMutuallyExclusive("json", "csv")
+}
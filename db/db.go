@@ -2,7 +2,7 @@ package db
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -19,145 +19,130 @@ const (
 	folderName = ".cligpt"
 )
 
-func getDbPath() string {
+var (
+	ErrNoSessions      = errors.New("no sessions found")
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSharedMessages is returned when messages can't be deleted because a
+	// fork shares them
+	ErrSharedMessages = errors.New("the messages are shared with fork")
+)
+
+func getDbPath() (string, error) {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	return filepath.Join(homedir, folderName, dbName)
+	return filepath.Join(homedir, folderName, dbName), nil
 }
 
-func getDb() *sql.DB {
-	filePath := getDbPath()
-
-	db, err := sql.Open("sqlite", filePath)
+func getDb() (*sql.DB, error) {
+	filePath, err := getDbPath()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return db
-}
-
-func GetLastTenSessions() []types.Session {
-	db := getDb()
-	defer db.Close()
-
-	rows, err := db.Query("SELECT * FROM sessions ORDER BY updated_at DESC LIMIT 10")
+	db, err := sql.Open("sqlite", filePath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("error opening database: %w", err)
 	}
-	defer rows.Close()
-
-	var sessions []types.Session
-	for rows.Next() {
-		var id int
-		var messages string
-		var updated_at string
 
-		err = rows.Scan(&id, &messages, &updated_at)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		var messagesArray []types.Message
-		err = json.Unmarshal([]byte(messages), &messagesArray)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		sessions = append(sessions, types.Session{
-			ID:       id,
-			Messages: messagesArray,
-		})
-
-	}
-
-	if len(sessions) == 0 {
-		log.Fatal("No sessions found")
+	if err := migrate(db, filePath); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return sessions
+	return db, nil
 }
 
-func CreateSession(messages []types.Message) types.Session {
-	db := getDb()
+// CreateSession saves a new session with its messages. A fork of session
+// parentID starts after its message forkedAt, both are 0 for a new chat. It
+// returns the session with the IDs of the messages set.
+func CreateSession(parentID int, forkedAt int, messages []types.Message) (types.Session, error) {
+	db, err := getDb()
+	if err != nil {
+		return types.Session{}, err
+	}
 	defer db.Close()
 
-	jsonMessages, err := json.Marshal(messages)
+	tx, err := db.Begin()
 	if err != nil {
-		log.Fatal("Error creating request body:", err)
+		return types.Session{}, fmt.Errorf("error creating session: %w", err)
 	}
+	defer tx.Rollback()
 
-	session, err := db.Exec("INSERT INTO sessions (messages) VALUES (?)", jsonMessages)
+	now := time.Now()
+	session, err := tx.Exec(
+		"INSERT INTO sessions (updated_at, parent_id, forked_at) VALUES (?, NULLIF(?, 0), NULLIF(?, 0))",
+		now.UTC().Format(timestampLayout), parentID, forkedAt,
+	)
 	if err != nil {
-		log.Fatal(err)
+		return types.Session{}, fmt.Errorf("error creating session: %w", err)
 	}
 
 	result, err := session.LastInsertId()
 	if err != nil {
-		log.Fatal(err)
+		return types.Session{}, fmt.Errorf("error creating session: %w", err)
+	}
+
+	saved := append([]types.Message{}, messages...)
+	if err := insertMessages(tx, int(result), forkedAt, saved, now); err != nil {
+		return types.Session{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return types.Session{}, fmt.Errorf("error creating session: %w", err)
 	}
 
 	return types.Session{
 		ID:       int(result),
-		Messages: messages,
-	}
+		Messages: saved,
+		ParentID: parentID,
+		ForkedAt: forkedAt,
+	}, nil
 }
 
-func UpdateSession(id int, messages []types.Message) {
-	db := getDb()
-	defer db.Close()
-
-	jsonMessages, err := json.Marshal(messages)
+// SaveSummary stores the summary standing in for the first upTo messages of
+// the session.
+func SaveSummary(id int, summary string, upTo int) error {
+	db, err := getDb()
 	if err != nil {
-		log.Fatal("Error creating request body:", err)
+		return err
 	}
+	defer db.Close()
 
-	_, err = db.Exec("UPDATE sessions SET messages = ?, updated_at = ? WHERE id = ?", jsonMessages, time.Now().UTC(), id)
+	_, err = db.Exec("UPDATE sessions SET summary = ?, summary_upto = ? WHERE id = ?", summary, upTo, id)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("error saving the summary of session %d: %w", id, err)
 	}
-}
 
-func InitDB() {
-	_, err := os.Stat(getDbPath())
-	if err == nil {
-		log.Default().Println("Database file found, skipping creation...")
-		return
-	}
+	return nil
+}
 
-	homedir, err := os.UserHomeDir()
+func InitDB() error {
+	path, err := getDbPath()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	pathToFolder := filepath.Join(homedir, folderName)
-
-	if _, err := os.Stat(pathToFolder); os.IsNotExist(err) {
-		err := os.Mkdir(pathToFolder, 0775)
-		if err != nil {
-			log.Fatal(err)
-		}
+	_, err = os.Stat(path)
+	if err == nil {
+		log.Default().Println("Database file found, skipping creation...")
+		return nil
 	}
 
-	f, err := os.Create(getDbPath())
-	if err != nil {
-		log.Fatal(err)
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
 	}
-	defer f.Close()
 
-	db, err := sql.Open("sqlite", getDbPath())
+	// Opening the database creates it with the latest schema
+	db, err := getDb()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer db.Close()
 
-	createQuery := "CREATE TABLE IF NOT EXISTS sessions (id INTEGER PRIMARY KEY, messages JSON, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
-	_, err = db.Exec(createQuery)
-	if err != nil {
-		log.Fatal(err)
-	}
+	fmt.Println("Database file created at: ", path)
 
-	fmt.Println("Database file created at: ", f.Name())
+	return nil
 }
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// ContentHash identifies a conversation by the roles and contents of its
+// messages, so the same conversation is recognized whatever tool it comes
+// from.
+func ContentHash(messages []types.Message) string {
+	hash := sha256.New()
+	for _, message := range messages {
+		fmt.Fprintf(hash, "%s\x00%s\x00", message.Role, message.Content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// SessionHashes returns the IDs of the sessions by the content hash of their
+// messages, and of the imported ones also by the hash they were imported
+// with, which still matches once the chat goes on.
+func SessionHashes() (map[string]int, error) {
+	db, err := getDb()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, COALESCE(forked_at, 0), COALESCE(import_hash, '') FROM sessions")
+	if err != nil {
+		return nil, fmt.Errorf("error reading sessions: %w", err)
+	}
+	defer rows.Close()
+
+	type sessionRow struct {
+		id         int
+		forkedAt   int
+		importHash string
+	}
+	var sessions []sessionRow
+	for rows.Next() {
+		var session sessionRow
+		if err := rows.Scan(&session.id, &session.forkedAt, &session.importHash); err != nil {
+			return nil, fmt.Errorf("error reading sessions: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading sessions: %w", err)
+	}
+	rows.Close()
+
+	hashes := map[string]int{}
+	for _, session := range sessions {
+		messages, err := getMessages(db, session.id, session.forkedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			hashes[ContentHash(messages)] = session.id
+		}
+		if session.importHash != "" {
+			hashes[session.importHash] = session.id
+		}
+	}
+
+	return hashes, nil
+}
+
+// ImportSession saves a conversation read from another tool or an export,
+// keeping its title and times. hash is its ContentHash, an import of the
+// same conversation fails. It returns the ID of the new session.
+func ImportSession(session types.Session, hash string) (int, error) {
+	db, err := getDb()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error importing session: %w", err)
+	}
+	defer tx.Rollback()
+
+	updatedAt := session.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO sessions (title, updated_at, import_hash) VALUES (NULLIF(?, ''), ?, ?)",
+		session.Title, updatedAt.UTC().Format(timestampLayout), hash,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error importing session: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error importing session: %w", err)
+	}
+
+	messages := append([]types.Message{}, session.Messages...)
+	if err := insertMessages(tx, int(id), 0, messages, updatedAt); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error importing session: %w", err)
+	}
+
+	return int(id), nil
+}
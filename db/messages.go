@@ -0,0 +1,206 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// messageMetadata holds the fields of a message that have no column of their
+// own.
+type messageMetadata struct {
+	Parts     []types.ContentPart `json:"parts,omitempty"`
+	Truncated bool                `json:"truncated,omitempty"`
+	Files     []string            `json:"files,omitempty"`
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertMessages saves the messages as a chain hanging off parentID, 0 for
+// the start of the session, and sets their IDs. Messages without a time get
+// createdAt.
+func insertMessages(tx execer, sessionID int, parentID int, messages []types.Message, createdAt time.Time) error {
+	for i := range messages {
+		message := &messages[i]
+		if message.CreatedAt.IsZero() {
+			message.CreatedAt = createdAt
+		}
+
+		var metadata interface{}
+		if len(message.Parts) > 0 || message.Truncated || len(message.Files) > 0 {
+			encoded, err := json.Marshal(messageMetadata{Parts: message.Parts, Truncated: message.Truncated, Files: message.Files})
+			if err != nil {
+				return fmt.Errorf("error encoding message: %w", err)
+			}
+			metadata = string(encoded)
+		}
+
+		parent := sql.NullInt64{Int64: int64(parentID), Valid: parentID != 0}
+		model := sql.NullString{String: message.Model, Valid: message.Model != ""}
+		tokens := sql.NullInt64{Int64: int64(message.Tokens), Valid: message.Tokens != 0}
+		result, err := tx.Exec(
+			"INSERT INTO messages (session_id, parent_id, role, content, model, tokens, metadata, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			sessionID, parent, message.Role, message.Content, model, tokens, metadata, message.CreatedAt.UTC().Format(timestampLayout),
+		)
+		if err != nil {
+			return fmt.Errorf("error saving message: %w", err)
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error saving message: %w", err)
+		}
+
+		message.ID = int(id)
+		message.ParentID = parentID
+		message.CreatedAt = message.CreatedAt.UTC().Truncate(time.Second)
+		parentID = message.ID
+	}
+
+	return nil
+}
+
+// AppendMessages saves the messages at the end of a session, after the
+// message parentID. It returns them with their IDs set.
+func AppendMessages(sessionID int, parentID int, messages []types.Message) ([]types.Message, error) {
+	db, err := getDb()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+	defer tx.Rollback()
+
+	saved := append([]types.Message{}, messages...)
+	now := time.Now()
+	if err := insertMessages(tx, sessionID, parentID, saved, now); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE sessions SET updated_at = ? WHERE id = ?", now.UTC().Format(timestampLayout), sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+
+	return saved, nil
+}
+
+// DeleteMessages deletes the messages of a session from the message fromID
+// on. It fails with ErrSharedMessages when a fork shares any of them.
+func DeleteMessages(sessionID int, fromID int) error {
+	db, err := getDb()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+	defer tx.Rollback()
+
+	var fork int
+	err = tx.QueryRow(
+		"SELECT id FROM sessions WHERE forked_at IN (SELECT id FROM messages WHERE session_id = ? AND id >= ?) LIMIT 1",
+		sessionID, fromID,
+	).Scan(&fork)
+	if err == nil {
+		return fmt.Errorf("%w %d", ErrSharedMessages, fork)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM messages WHERE session_id = ? AND id >= ?", sessionID, fromID); err != nil {
+		return fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+
+	_, err = tx.Exec("UPDATE sessions SET updated_at = ? WHERE id = ?", time.Now().UTC().Format(timestampLayout), sessionID)
+	if err != nil {
+		return fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error updating session %d: %w", sessionID, err)
+	}
+
+	return nil
+}
+
+const messageColumns = `messages.id, COALESCE(messages.parent_id, 0), messages.role, messages.content, COALESCE(messages.model, ''),
+	COALESCE(messages.tokens, 0), COALESCE(messages.metadata, ''), messages.created_at`
+
+// getMessages returns the messages of a session in order, starting with the
+// ones a fork shares with its parent up to the message forkedAt.
+func getMessages(db *sql.DB, sessionID int, forkedAt int) ([]types.Message, error) {
+	var messages []types.Message
+	if forkedAt != 0 {
+		// Walk up the parents of the message, the shared messages may span
+		// several sessions when forks are forked
+		history, err := queryMessages(db, `WITH RECURSIVE path (id, depth) AS (
+				SELECT ?, 0
+				UNION ALL
+				SELECT messages.parent_id, path.depth + 1 FROM messages JOIN path ON messages.id = path.id WHERE messages.parent_id IS NOT NULL
+			)
+			SELECT `+messageColumns+` FROM path JOIN messages ON messages.id = path.id ORDER BY path.depth DESC`, forkedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading session %d: %w", sessionID, err)
+		}
+		messages = history
+	}
+
+	own, err := queryMessages(db, "SELECT "+messageColumns+" FROM messages WHERE session_id = ? ORDER BY id", sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading session %d: %w", sessionID, err)
+	}
+
+	return append(messages, own...), nil
+}
+
+func queryMessages(db *sql.DB, query string, args ...interface{}) ([]types.Message, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []types.Message
+	for rows.Next() {
+		var message types.Message
+		var metadata string
+
+		err := rows.Scan(&message.ID, &message.ParentID, &message.Role, &message.Content, &message.Model, &message.Tokens, &metadata, &message.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if metadata != "" {
+			var decoded messageMetadata
+			if err := json.Unmarshal([]byte(metadata), &decoded); err != nil {
+				return nil, fmt.Errorf("error parsing message %d: %w", message.ID, err)
+			}
+			message.Parts = decoded.Parts
+			message.Truncated = decoded.Truncated
+			message.Files = decoded.Files
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
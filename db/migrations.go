@@ -0,0 +1,334 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// migration moves the schema one version up. Destructive migrations rewrite
+// or drop data, the database is backed up before they run.
+type migration struct {
+	name        string
+	destructive bool
+	up          func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, the schema version stored in PRAGMA
+// user_version is the number of migrations applied. Never edit or reorder a
+// released migration, append a new one instead.
+var migrations = []migration{
+	{
+		name: "create sessions",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE IF NOT EXISTS sessions (id INTEGER PRIMARY KEY, messages JSON, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)")
+			return err
+		},
+	},
+	{
+		name: "add session summaries",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "sessions", "summary", "TEXT"); err != nil {
+				return err
+			}
+			return addColumn(tx, "sessions", "summary_upto", "INTEGER NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		name: "create usage",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE IF NOT EXISTS usage (id INTEGER PRIMARY KEY, session_id INTEGER, model TEXT NOT NULL, prompt_tokens INTEGER NOT NULL DEFAULT 0, completion_tokens INTEGER NOT NULL DEFAULT 0, cost REAL NOT NULL DEFAULT 0, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)")
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("CREATE INDEX IF NOT EXISTS usage_created_at ON usage (created_at)")
+			return err
+		},
+	},
+	{
+		name: "add usage images",
+		up: func(tx *sql.Tx) error {
+			return addColumn(tx, "usage", "images", "INTEGER NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		name:        "move messages to their own table",
+		destructive: true,
+		up:          moveMessagesToTable,
+	},
+	{
+		name: "add session titles",
+		up: func(tx *sql.Tx) error {
+			return addColumn(tx, "sessions", "title", "TEXT")
+		},
+	},
+	{
+		name: "index messages for search",
+		up: func(tx *sql.Tx) error {
+			statements := []string{
+				// The index reads the content from the messages table
+				"CREATE VIRTUAL TABLE messages_fts USING fts5(content, content='messages', content_rowid='id', tokenize='unicode61 remove_diacritics 2')",
+				"INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')",
+				`CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+					INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+				END`,
+				`CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+					INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+				END`,
+				`CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+					INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+					INSERT INTO messages_fts (rowid, content) VALUES (new.id, new.content);
+				END`,
+			}
+			for _, statement := range statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name: "add session forks",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "sessions", "parent_id", "INTEGER REFERENCES sessions (id)"); err != nil {
+				return err
+			}
+			return addColumn(tx, "sessions", "forked_at", "INTEGER REFERENCES messages (id)")
+		},
+	},
+	{
+		name: "add session imports",
+		up: func(tx *sql.Tx) error {
+			if err := addColumn(tx, "sessions", "import_hash", "TEXT"); err != nil {
+				return err
+			}
+			_, err := tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS sessions_import_hash ON sessions (import_hash)")
+			return err
+		},
+	},
+}
+
+// migrate brings the schema of the database at path up to date. Databases
+// from before the schema was versioned have version 0, so every step has to
+// cope with the tables and columns they may already have.
+func migrate(db *sql.DB, path string) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("error reading the database version: %w", err)
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("the database was created by a newer version of cligpt (schema %d, this version knows up to %d), please upgrade", version, len(migrations))
+	}
+
+	pending := migrations[version:]
+	for _, m := range pending {
+		if m.destructive {
+			if err := backup(db, path, version); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for i, m := range pending {
+		if err := applyMigration(db, m, version+i+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error upgrading the database: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return fmt.Errorf("error upgrading the database to version %d (%s): %w", version, m.name, err)
+	}
+
+	// user_version is part of the transaction, a failed step is retried on
+	// the next run
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("error upgrading the database to version %d (%s): %w", version, m.name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error upgrading the database to version %d (%s): %w", version, m.name, err)
+	}
+
+	return nil
+}
+
+// backup copies the database next to it before a destructive migration. A
+// database without any rows, like one that was just created, isn't copied.
+func backup(db *sql.DB, path string, version int) error {
+	empty, err := isEmpty(db)
+	if err != nil {
+		return fmt.Errorf("error backing up the database: %w", err)
+	}
+	if empty {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return fmt.Errorf("error backing up the database to %s: %w", backupPath, err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Upgrading the database, a backup was saved to", backupPath)
+
+	return nil
+}
+
+func isEmpty(db *sql.DB) (bool, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		var found bool
+		query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s")`, strings.ReplaceAll(table, `"`, `""`))
+		if err := db.QueryRow(query).Scan(&found); err != nil {
+			return false, err
+		}
+		if found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// addColumn adds a column unless the table already has it, databases upgraded
+// by versions before the schema was versioned may have it already.
+func addColumn(tx *sql.Tx, table string, column string, definition string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// moveMessagesToTable splits the messages JSON column of the sessions into
+// rows of the messages table. The messages of a session get its updated_at
+// as their time, older times were never stored. updated_at is rewritten in
+// timestampLayout, older versions stored it in several formats which don't
+// sort as text.
+func moveMessagesToTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE messages (
+		id INTEGER PRIMARY KEY,
+		session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+		parent_id INTEGER REFERENCES messages (id),
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		model TEXT,
+		tokens INTEGER,
+		metadata JSON,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE INDEX messages_session_id ON messages (session_id, id)"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, COALESCE(messages, '[]'), updated_at FROM sessions")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type legacySession struct {
+		id        int
+		messages  string
+		updatedAt sql.NullTime
+	}
+	var sessions []legacySession
+	for rows.Next() {
+		var session legacySession
+		if err := rows.Scan(&session.id, &session.messages, &session.updatedAt); err != nil {
+			return err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, session := range sessions {
+		var messages []types.Message
+		if err := json.Unmarshal([]byte(session.messages), &messages); err != nil {
+			// It couldn't be opened before either, it is still in the backup
+			fmt.Fprintf(os.Stderr, "Warning: session %d can't be read and was left out: %v\n", session.id, err)
+			continue
+		}
+
+		createdAt := time.Now()
+		if session.updatedAt.Valid {
+			createdAt = session.updatedAt.Time
+		}
+
+		if err := insertMessages(tx, session.id, 0, messages, createdAt); err != nil {
+			return err
+		}
+	}
+
+	for _, session := range sessions {
+		if !session.updatedAt.Valid {
+			continue
+		}
+		_, err := tx.Exec("UPDATE sessions SET updated_at = ? WHERE id = ?", session.updatedAt.Time.UTC().Format(timestampLayout), session.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("ALTER TABLE sessions DROP COLUMN messages")
+	return err
+}
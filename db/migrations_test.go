@@ -0,0 +1,364 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// useTempHome points the database at a new home directory and returns its
+// path.
+func useTempHome(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	if err := os.MkdirAll(filepath.Join(home, folderName), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := getDbPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// legacySession is a session as versions before the schema was versioned
+// stored it, with its messages as JSON in the sessions table.
+type legacySession struct {
+	messages string
+	// updatedAt is passed to the driver as UpdateSession did, the zero time
+	// keeps the CURRENT_TIMESTAMP default of a session that was never updated
+	updatedAt time.Time
+}
+
+// createLegacyDb writes a version 0 database with the schema the first
+// versions of cligpt created.
+func createLegacyDb(t *testing.T, path string, sessions []legacySession) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS sessions (id INTEGER PRIMARY KEY, messages JSON, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range sessions {
+		result, err := db.Exec("INSERT INTO sessions (messages) VALUES (?)", session.messages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.updatedAt.IsZero() {
+			continue
+		}
+
+		id, _ := result.LastInsertId()
+		if _, err := db.Exec("UPDATE sessions SET messages = ?, updated_at = ? WHERE id = ?", session.messages, session.updatedAt, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func legacyMessages(t *testing.T, messages ...types.Message) string {
+	t.Helper()
+
+	data, err := json.Marshal(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func countRows(t *testing.T, db *sql.DB, query string) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow(query).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	return countRows(t, db, "PRAGMA user_version")
+}
+
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(path + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return matches
+}
+
+func TestMigrateLegacyDb(t *testing.T) {
+	path := useTempHome(t)
+	updatedAt := time.Date(2023, 4, 5, 6, 7, 8, 908674000, time.UTC)
+	createLegacyDb(t, path, []legacySession{
+		{messages: legacyMessages(t,
+			types.Message{Role: "system", Content: "be brief"},
+			types.Message{Role: "user", Content: "hi"},
+			types.Message{Role: "assistant", Content: "hello"},
+		)},
+		{messages: legacyMessages(t,
+			types.Message{Role: "user", Content: "what is 2+2?"},
+			types.Message{Role: "assistant", Content: "4"},
+			types.Message{Role: "user", Content: "and 3+3?"},
+			types.Message{Role: "assistant", Content: "6"},
+		), updatedAt: updatedAt},
+		// It couldn't be opened before either, the session stays without
+		// messages
+		{messages: "not json", updatedAt: updatedAt},
+	})
+
+	db, err := getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if version := schemaVersion(t, db); version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	if count := countRows(t, db, "SELECT COUNT(*) FROM sessions"); count != 3 {
+		t.Errorf("%d sessions, want 3", count)
+	}
+	if count := countRows(t, db, "SELECT COUNT(*) FROM messages"); count != 7 {
+		t.Errorf("%d messages, want 7", count)
+	}
+	if count := countRows(t, db, "SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name = 'messages'"); count != 0 {
+		t.Error("the messages column of the sessions table is still there")
+	}
+
+	// The backup holds the database as it was
+	files := backups(t, path)
+	if len(files) != 1 {
+		t.Fatalf("backups = %v, want one", files)
+	}
+	backup, err := sql.Open("sqlite", files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if version := schemaVersion(t, backup); version != 0 {
+		t.Errorf("backup user_version = %d, want 0", version)
+	}
+	if count := countRows(t, backup, "SELECT COUNT(*) FROM sessions WHERE messages IS NOT NULL"); count != 3 {
+		t.Errorf("backup has %d sessions, want 3", count)
+	}
+
+	session, err := GetSession(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for i, message := range session.Messages {
+		contents = append(contents, message.Content)
+		if i > 0 && message.ParentID != session.Messages[i-1].ID {
+			t.Errorf("message %d follows %d, want %d", i, message.ParentID, session.Messages[i-1].ID)
+		}
+		if !message.CreatedAt.Equal(updatedAt.Truncate(time.Second)) {
+			t.Errorf("message %d was created at %s, want the time of the session %s", i, message.CreatedAt, updatedAt)
+		}
+	}
+	if len(contents) != 4 || contents[0] != "what is 2+2?" || contents[3] != "6" {
+		t.Errorf("messages = %q", contents)
+	}
+
+	session, err = GetSession(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Messages) != 3 || session.Messages[0].Role != "system" {
+		t.Errorf("messages = %+v", session.Messages)
+	}
+	if session.UpdatedAt.IsZero() {
+		t.Error("the CURRENT_TIMESTAMP of the session was lost")
+	}
+
+	// Once upgraded, opening it again changes nothing
+	db.Close()
+	db, err = getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if version := schemaVersion(t, db); version != len(migrations) {
+		t.Errorf("user_version = %d after the second run, want %d", version, len(migrations))
+	}
+	if count := countRows(t, db, "SELECT COUNT(*) FROM messages"); count != 7 {
+		t.Errorf("%d messages after the second run, want 7", count)
+	}
+	if files := backups(t, path); len(files) != 1 {
+		t.Errorf("backups = %v after the second run, want one", files)
+	}
+}
+
+func TestMigrateNewDb(t *testing.T) {
+	path := useTempHome(t)
+
+	if err := InitDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if version := schemaVersion(t, db); version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	if files := backups(t, path); len(files) != 0 {
+		t.Errorf("backups = %v, an empty database shouldn't be backed up", files)
+	}
+}
+
+func TestMigrateEmptyLegacyDb(t *testing.T) {
+	path := useTempHome(t)
+	createLegacyDb(t, path, nil)
+
+	db, err := getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if version := schemaVersion(t, db); version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	if files := backups(t, path); len(files) != 0 {
+		t.Errorf("backups = %v, an empty database shouldn't be backed up", files)
+	}
+}
+
+func TestMigrateNewerDb(t *testing.T) {
+	path := useTempHome(t)
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("PRAGMA user_version = 1000"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db, path); err == nil {
+		t.Error("a database of a newer version was opened")
+	}
+	if version := schemaVersion(t, db); version != 1000 {
+		t.Errorf("user_version = %d, want it left at 1000", version)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	path := useTempHome(t)
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, step := range []struct {
+		statement string
+		empty     bool
+	}{
+		{"", true},
+		{`CREATE TABLE "odd ""name""" (id INTEGER)`, true},
+		{"CREATE TABLE usage (id INTEGER)", true},
+		{"INSERT INTO usage (id) VALUES (1)", false},
+	} {
+		if step.statement != "" {
+			if _, err := db.Exec(step.statement); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		empty, err := isEmpty(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if empty != step.empty {
+			t.Errorf("after %q: isEmpty = %v, want %v", step.statement, empty, step.empty)
+		}
+	}
+}
+
+func TestMigrateLegacyUpdatedAt(t *testing.T) {
+	path := useTempHome(t)
+	messages := legacyMessages(t, types.Message{Role: "user", Content: "hi"})
+	createLegacyDb(t, path, []legacySession{
+		// Written by the driver from a time.Time, with a T and fractions
+		{messages: messages, updatedAt: time.Date(2023, 4, 5, 7, 9, 18, 908674000, time.UTC)},
+		{messages: messages},
+		{messages: messages},
+	})
+
+	// CURRENT_TIMESTAMP later on the same day, and RFC 3339 as other versions
+	// of the driver write it
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"UPDATE sessions SET updated_at = '2023-04-05 08:00:00' WHERE id = 2",
+		"UPDATE sessions SET updated_at = '2023-04-05T07:30:00.123456Z' WHERE id = 3",
+	} {
+		if _, err := legacy.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy.Close()
+
+	db, err := getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	want := map[int]string{1: "2023-04-05 07:09:18", 2: "2023-04-05 08:00:00", 3: "2023-04-05 07:30:00"}
+	for id, updatedAt := range want {
+		var stored string
+		if err := db.QueryRow("SELECT CAST(updated_at AS TEXT) FROM sessions WHERE id = ?", id).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != updatedAt {
+			t.Errorf("session %d updated_at = %q, want %q", id, stored, updatedAt)
+		}
+	}
+
+	infos, _, err := ListSessions(-1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+	}
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 3 || ids[2] != 1 {
+		t.Errorf("sessions are listed as %v, want [2 3 1]", ids)
+	}
+}
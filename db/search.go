@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// Search filters the messages matched by a full-text query. Zero values don't
+// filter.
+type Search struct {
+	Query string
+	Role  string
+	// Model matches models starting with it
+	Model string
+	Since time.Time
+	Until time.Time
+	Limit int
+	// Highlight is put around the matched words of the snippets
+	HighlightStart string
+	HighlightEnd   string
+}
+
+// ftsQuery turns the words of a query into an FTS5 query matching messages
+// that hold all of them, so punctuation can't break the query syntax. A
+// trailing * matches a prefix.
+func ftsQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		prefix := strings.HasSuffix(word, "*")
+		word = strings.TrimRight(word, "*")
+		if word == "" {
+			continue
+		}
+
+		term := `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// SearchMessages returns the messages matching the search, best matches
+// first.
+func SearchMessages(search Search) ([]types.SearchResult, error) {
+	query := ftsQuery(search.Query)
+	if query == "" {
+		return nil, nil
+	}
+
+	db, err := getDb()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	conditions := []string{"messages_fts MATCH ?"}
+	args := []interface{}{search.HighlightStart, search.HighlightEnd, query}
+	if search.Role != "" {
+		conditions = append(conditions, "m.role = ?")
+		args = append(args, search.Role)
+	}
+	if search.Model != "" {
+		conditions = append(conditions, "substr(m.model, 1, length(?)) = ?")
+		args = append(args, search.Model, search.Model)
+	}
+	if !search.Since.IsZero() {
+		conditions = append(conditions, "m.created_at >= ?")
+		args = append(args, search.Since.UTC().Format(timestampLayout))
+	}
+	if !search.Until.IsZero() {
+		conditions = append(conditions, "m.created_at < ?")
+		args = append(args, search.Until.UTC().Format(timestampLayout))
+	}
+
+	limit := search.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`SELECT m.session_id, COALESCE(s.title, ''), m.id, m.role, COALESCE(m.model, ''),
+		snippet(messages_fts, 0, ?, ?, '...', 16), m.created_at
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		JOIN sessions s ON s.id = m.session_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY rank LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error searching messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []types.SearchResult
+	for rows.Next() {
+		var result types.SearchResult
+		err := rows.Scan(&result.SessionID, &result.SessionTitle, &result.MessageID, &result.Role, &result.Model, &result.Snippet, &result.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error searching messages: %w", err)
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error searching messages: %w", err)
+	}
+
+	return results, nil
+}
+
+// SearchSessions returns the IDs of the sessions with a message matching the
+// query.
+func SearchSessions(query string) (map[int]bool, error) {
+	ids := map[int]bool{}
+
+	match := ftsQuery(query)
+	if match == "" {
+		return ids, nil
+	}
+
+	db, err := getDb()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT DISTINCT m.session_id FROM messages_fts JOIN messages m ON m.id = messages_fts.rowid WHERE messages_fts MATCH ?", match)
+	if err != nil {
+		return nil, fmt.Errorf("error searching sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error searching sessions: %w", err)
+		}
+		ids[id] = true
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+func TestFtsQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		match string
+	}{
+		{
+			name:  "empty",
+			query: "   ",
+			match: "",
+		},
+		{
+			name:  "words",
+			query: "goroutine  leak\n",
+			match: `"goroutine" "leak"`,
+		},
+		{
+			name:  "quotes are doubled",
+			query: `say "hi"`,
+			match: `"say" """hi"""`,
+		},
+		{
+			name:  "operators are words",
+			query: "cats NOT dogs OR birds",
+			match: `"cats" "NOT" "dogs" "OR" "birds"`,
+		},
+		{
+			name:  "punctuation",
+			query: "a-b (c) d:e ^f",
+			match: `"a-b" "(c)" "d:e" "^f"`,
+		},
+		{
+			name:  "trailing star",
+			query: "gorout*",
+			match: `"gorout"*`,
+		},
+		{
+			name:  "several stars",
+			query: "gorout**",
+			match: `"gorout"*`,
+		},
+		{
+			name:  "star inside a word",
+			query: "go*routine",
+			match: `"go*routine"`,
+		},
+		{
+			name:  "lone star",
+			query: "* leak",
+			match: `"leak"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := ftsQuery(test.query); match != test.match {
+				t.Errorf("ftsQuery(%q) = %s, want %s", test.query, match, test.match)
+			}
+		})
+	}
+}
+
+// The queries built by ftsQuery are valid FTS5 syntax, whatever is typed.
+func TestSearchMessagesQuerySyntax(t *testing.T) {
+	useTempHome(t)
+
+	_, err := CreateSession(0, 0, []types.Message{
+		{Role: "user", Content: "Why does my goroutine leak?"},
+		{Role: "assistant", Content: `The channel is never closed, say "close(ch)" when done.`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query   string
+		matches int
+	}{
+		{"goroutine", 1},
+		{"GOROUTINE leak", 1},
+		{"gorout*", 1},
+		{"gorout", 0},
+		{"goroutine channel", 0},
+		{`"close(ch)"`, 1},
+		{"close(ch", 1},
+		{"NOT", 0},
+		{"leak OR", 0},
+		{`"`, 0},
+		{"*", 0},
+		{"a-b:c^", 0},
+	}
+
+	for _, test := range tests {
+		results, err := SearchMessages(Search{Query: test.query})
+		if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+		if len(results) != test.matches {
+			t.Errorf("%q matched %d messages, want %d", test.query, len(results), test.matches)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// Sessions without messages are never shown, they are left behind by chats
+// that were cancelled before the first answer. Forks are shown as soon as
+// they are made, they share the messages of their parent.
+const visible = "(parent_id IS NOT NULL OR EXISTS (SELECT 1 FROM messages WHERE session_id = sessions.id))"
+
+// ListSessions returns a page of the sessions, most recently updated first,
+// and the number of sessions.
+func ListSessions(limit int, offset int) ([]types.SessionInfo, int, error) {
+	db, err := getDb()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE " + visible).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error reading sessions: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT id, COALESCE(title, ''), updated_at, COALESCE(parent_id, 0),
+		(SELECT COUNT(*) FROM messages WHERE session_id = sessions.id),
+		COALESCE((SELECT substr(content, 1, 200) FROM messages WHERE session_id = sessions.id AND role = 'user' ORDER BY id LIMIT 1), '')
+		FROM sessions WHERE `+visible+` ORDER BY updated_at DESC, id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []types.SessionInfo
+	for rows.Next() {
+		var session types.SessionInfo
+		if err := rows.Scan(&session.ID, &session.Title, &session.UpdatedAt, &session.ParentID, &session.Messages, &session.Preview); err != nil {
+			return nil, 0, fmt.Errorf("error reading sessions: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading sessions: %w", err)
+	}
+
+	return sessions, total, nil
+}
+
+// GetSession returns a session with its messages.
+func GetSession(id int) (types.Session, error) {
+	db, err := getDb()
+	if err != nil {
+		return types.Session{}, err
+	}
+	defer db.Close()
+
+	session := types.Session{ID: id}
+	err = db.QueryRow("SELECT COALESCE(title, ''), updated_at, COALESCE(summary, ''), summary_upto, COALESCE(parent_id, 0), COALESCE(forked_at, 0) FROM sessions WHERE id = ? AND "+visible, id).
+		Scan(&session.Title, &session.UpdatedAt, &session.Summary, &session.SummaryUpTo, &session.ParentID, &session.ForkedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.Session{}, fmt.Errorf("%w: %d", ErrSessionNotFound, id)
+	}
+	if err != nil {
+		return types.Session{}, fmt.Errorf("error reading session %d: %w", id, err)
+	}
+
+	session.Messages, err = getMessages(db, id, session.ForkedAt)
+	if err != nil {
+		return types.Session{}, err
+	}
+
+	return session, nil
+}
+
+// DeleteSessions deletes the sessions and their messages. Nothing is deleted
+// unless all of them exist, and sessions with forks can only be deleted along
+// with their forks. Their usage is kept for the usage reports.
+func DeleteSessions(ids []int) error {
+	db, err := getDb()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted := map[int]bool{}
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	for _, id := range ids {
+		forks, err := getForks(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, fork := range forks {
+			if !deleted[fork] {
+				return fmt.Errorf("session %d can't be deleted without its fork %d, it shares messages with it", id, fork)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM messages WHERE session_id = ?", id); err != nil {
+			return fmt.Errorf("error deleting session %d: %w", id, err)
+		}
+
+		result, err := tx.Exec("DELETE FROM sessions WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("error deleting session %d: %w", id, err)
+		}
+		if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+			return fmt.Errorf("%w: %d", ErrSessionNotFound, id)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+
+	return nil
+}
+
+// RenameSession sets the title of a session, an empty title clears it.
+func RenameSession(id int, title string) error {
+	db, err := getDb()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Exec("UPDATE sessions SET title = NULLIF(?, '') WHERE id = ?", title, id)
+	if err != nil {
+		return fmt.Errorf("error renaming session %d: %w", id, err)
+	}
+	if renamed, err := result.RowsAffected(); err == nil && renamed == 0 {
+		return fmt.Errorf("%w: %d", ErrSessionNotFound, id)
+	}
+
+	return nil
+}
+
+func getForks(tx *sql.Tx, id int) ([]int, error) {
+	rows, err := tx.Query("SELECT id FROM sessions WHERE parent_id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the forks of session %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	var forks []int
+	for rows.Next() {
+		var fork int
+		if err := rows.Scan(&fork); err != nil {
+			return nil, fmt.Errorf("error reading the forks of session %d: %w", id, err)
+		}
+		forks = append(forks, fork)
+	}
+
+	return forks, rows.Err()
+}
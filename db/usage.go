@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/eitamonya/cligpt/types"
+)
+
+// timestampLayout matches the format of CURRENT_TIMESTAMP, so timestamps can
+// be compared as text.
+const timestampLayout = "2006-01-02 15:04:05"
+
+func AddUsage(usage types.UsageRecord) error {
+	db, err := getDb()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sessionID := sql.NullInt64{Int64: int64(usage.SessionID), Valid: usage.SessionID != 0}
+
+	_, err = db.Exec(
+		"INSERT INTO usage (session_id, model, prompt_tokens, completion_tokens, images, cost) VALUES (?, ?, ?, ?, ?, ?)",
+		sessionID, usage.Model, usage.PromptTokens, usage.CompletionTokens, usage.Images, usage.Cost,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving usage: %w", err)
+	}
+
+	return nil
+}
+
+// GetUsage returns the usage recorded since the given time, oldest first.
+func GetUsage(since time.Time) ([]types.UsageRecord, error) {
+	db, err := getDb()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(
+		"SELECT COALESCE(session_id, 0), model, prompt_tokens, completion_tokens, images, cost, created_at FROM usage WHERE created_at >= ? ORDER BY created_at, id",
+		since.UTC().Format(timestampLayout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading usage: %w", err)
+	}
+	defer rows.Close()
+
+	var records []types.UsageRecord
+	for rows.Next() {
+		var record types.UsageRecord
+
+		err := rows.Scan(&record.SessionID, &record.Model, &record.PromptTokens, &record.CompletionTokens, &record.Images, &record.Cost, &record.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading usage: %w", err)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading usage: %w", err)
+	}
+
+	return records, nil
+}
+
+// GetCost returns the cost of the usage recorded since the given time.
+func GetCost(since time.Time) (float64, error) {
+	db, err := getDb()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var cost float64
+	err = db.QueryRow("SELECT COALESCE(SUM(cost), 0) FROM usage WHERE created_at >= ?", since.UTC().Format(timestampLayout)).Scan(&cost)
+	if err != nil {
+		return 0, fmt.Errorf("error reading usage: %w", err)
+	}
+
+	return cost, nil
+}
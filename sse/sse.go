@@ -0,0 +1,144 @@
+// Package sse reads server-sent event streams as described in the HTML
+// Living Standard (https://html.spec.whatwg.org/multipage/server-sent-events.html).
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const defaultEventType = "message"
+
+// Event is a single dispatched event. Multi-line data fields are joined with
+// newlines.
+type Event struct {
+	Type string
+	// ID is the last event ID seen in the stream, which carries over to the
+	// following events
+	ID    string
+	Data  string
+	Retry int
+}
+
+type Reader struct {
+	reader    *bufio.Reader
+	lastID    string
+	started   bool
+	eventType string
+	data      strings.Builder
+	hasData   bool
+	retry     int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next event in the stream. It returns io.EOF once the stream
+// ends, an event that was not terminated by a blank line is discarded.
+func (r *Reader) Next() (Event, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+
+		if line == "" {
+			if event, ok := r.dispatch(); ok {
+				return event, nil
+			}
+			continue
+		}
+
+		r.processLine(line)
+	}
+}
+
+// readLine reads a line ended by CRLF, LF or CR, without the line ending.
+func (r *Reader) readLine() (string, error) {
+	var line strings.Builder
+
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			// A last line without a line ending is incomplete
+			return "", err
+		}
+
+		switch b {
+		case '\n':
+			return r.stripBOM(line.String()), nil
+		case '\r':
+			if next, err := r.reader.Peek(1); err == nil && next[0] == '\n' {
+				r.reader.ReadByte()
+			}
+			return r.stripBOM(line.String()), nil
+		default:
+			line.WriteByte(b)
+		}
+	}
+}
+
+func (r *Reader) stripBOM(line string) string {
+	if !r.started {
+		r.started = true
+		return strings.TrimPrefix(line, "\uFEFF")
+	}
+
+	return line
+}
+
+func (r *Reader) processLine(line string) {
+	// Lines starting with a colon are comments, often used as keep-alives
+	if strings.HasPrefix(line, ":") {
+		return
+	}
+
+	field, value, found := strings.Cut(line, ":")
+	if found {
+		value = strings.TrimPrefix(value, " ")
+	}
+
+	switch field {
+	case "event":
+		r.eventType = value
+	case "data":
+		r.data.WriteString(value)
+		r.data.WriteByte('\n')
+		r.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			r.lastID = value
+		}
+	case "retry":
+		if retry, err := strconv.Atoi(value); err == nil && retry >= 0 {
+			r.retry = retry
+		}
+	}
+}
+
+func (r *Reader) dispatch() (Event, bool) {
+	defer func() {
+		r.eventType = ""
+		r.data.Reset()
+		r.hasData = false
+	}()
+
+	if !r.hasData {
+		return Event{}, false
+	}
+
+	event := Event{
+		Type:  r.eventType,
+		ID:    r.lastID,
+		Data:  strings.TrimSuffix(r.data.String(), "\n"),
+		Retry: r.retry,
+	}
+	if event.Type == "" {
+		event.Type = defaultEventType
+	}
+
+	return event, true
+}
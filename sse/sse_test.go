@@ -0,0 +1,159 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readAll returns the events of a stream and the error that ended it.
+func readAll(r io.Reader) ([]Event, error) {
+	reader := NewReader(r)
+
+	var events []Event
+	for {
+		event, err := reader.Next()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		events []Event
+	}{
+		{
+			name:   "LF",
+			stream: "data: one\n\ndata: two\n\n",
+			events: []Event{{Type: "message", Data: "one"}, {Type: "message", Data: "two"}},
+		},
+		{
+			name:   "CRLF",
+			stream: "data: one\r\n\r\ndata: two\r\n\r\n",
+			events: []Event{{Type: "message", Data: "one"}, {Type: "message", Data: "two"}},
+		},
+		{
+			name:   "CR",
+			stream: "data: one\r\rdata: two\r\r",
+			events: []Event{{Type: "message", Data: "one"}, {Type: "message", Data: "two"}},
+		},
+		{
+			name:   "mixed line endings",
+			stream: "data: one\r\ndata: two\n\rdata: three\r\r\n",
+			events: []Event{{Type: "message", Data: "one\ntwo"}, {Type: "message", Data: "three"}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: {\ndata:   \"a\": 1\ndata: }\n\n",
+			events: []Event{{Type: "message", Data: "{\n  \"a\": 1\n}"}},
+		},
+		{
+			name:   "empty data lines",
+			stream: "data\ndata:\ndata: x\n\n",
+			events: []Event{{Type: "message", Data: "\n\nx"}},
+		},
+		{
+			name:   "only the first space is removed",
+			stream: "data:no space\n\ndata:  two spaces\n\n",
+			events: []Event{{Type: "message", Data: "no space"}, {Type: "message", Data: " two spaces"}},
+		},
+		{
+			name:   "event type",
+			stream: "event: content_block_delta\ndata: x\n\ndata: y\n\n",
+			events: []Event{{Type: "content_block_delta", Data: "x"}, {Type: "message", Data: "y"}},
+		},
+		{
+			name:   "ID carries over",
+			stream: "id: 1\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			events: []Event{{Type: "message", ID: "1", Data: "a"}, {Type: "message", ID: "1", Data: "b"}, {Type: "message", Data: "c"}},
+		},
+		{
+			name:   "ID with NUL is ignored",
+			stream: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			events: []Event{{Type: "message", ID: "1", Data: "a"}, {Type: "message", ID: "1", Data: "b"}},
+		},
+		{
+			name:   "retry",
+			stream: "retry: 3000\ndata: a\n\nretry: soon\ndata: b\n\n",
+			events: []Event{{Type: "message", Data: "a", Retry: 3000}, {Type: "message", Data: "b", Retry: 3000}},
+		},
+		{
+			name:   "comment keep-alives",
+			stream: ": ping\n\n:\ndata: a\n: in between\ndata: b\n\n: ping\n\n",
+			events: []Event{{Type: "message", Data: "a\nb"}},
+		},
+		{
+			name:   "unknown fields",
+			stream: "foo: bar\ndata: a\n\n",
+			events: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "event without data is not dispatched",
+			stream: "event: ping\n\nid: 7\n\ndata: a\n\n",
+			events: []Event{{Type: "message", ID: "7", Data: "a"}},
+		},
+		{
+			name:   "leading BOM",
+			stream: "\uFEFFdata: a\n\n",
+			events: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "only the first BOM is removed",
+			stream: "\uFEFFdata: a\n\n\uFEFFdata: b\n\n",
+			events: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "trailing event without a blank line is dropped",
+			stream: "data: a\n\ndata: b\n",
+			events: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "trailing line without a line ending is dropped",
+			stream: "data: a\n\ndata: b",
+			events: []Event{{Type: "message", Data: "a"}},
+		},
+		{
+			name:   "empty stream",
+			stream: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Reading a byte at a time splits the CRLF line endings too
+			for _, r := range []io.Reader{strings.NewReader(test.stream), iotest.OneByteReader(strings.NewReader(test.stream))} {
+				events, err := readAll(r)
+				if err != io.EOF {
+					t.Fatalf("error = %v, want io.EOF", err)
+				}
+				if len(events) != len(test.events) {
+					t.Fatalf("events = %+v, want %+v", events, test.events)
+				}
+				for i := range events {
+					if events[i] != test.events[i] {
+						t.Errorf("event %d = %+v, want %+v", i, events[i], test.events[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	errCut := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("data: a\n\ndata: b\n"), iotest.ErrReader(errCut))
+
+	events, err := readAll(r)
+	if !errors.Is(err, errCut) {
+		t.Fatalf("error = %v, want %v", err, errCut)
+	}
+	if len(events) != 1 || events[0].Data != "a" {
+		t.Errorf("events = %+v, want only the complete one", events)
+	}
+}
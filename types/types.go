@@ -1,11 +1,167 @@
 package types
 
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
 type Message struct {
-	Role    string `json:"role"`
+	Role string `json:"role"`
+	// Content is the text of the message, for messages with parts the text of
+	// all the text parts
 	Content string `json:"content"`
+	// Parts is only set for messages that mix text and images
+	Parts []ContentPart `json:"-"`
+	// Truncated is set when the answer was interrupted before it finished
+	Truncated bool `json:"truncated,omitempty"`
+	// Files holds the paths of the files attached to the message
+	Files []string `json:"files,omitempty"`
+	// ID and ParentID are set once the message is saved, the first message of
+	// a session has no parent
+	ID       int `json:"id,omitempty"`
+	ParentID int `json:"parent_id,omitempty"`
+	// Model is the model that wrote an answer
+	Model string `json:"model,omitempty"`
+	// Tokens is the size of the message in tokens
+	Tokens    int       `json:"tokens,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ContentPart is a part of a message in the OpenAI format, either text or an
+// image given as a URL or a base64 data URI.
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
 }
 
 type Session struct {
 	Messages []Message `json:"messages"`
-	ID       int    `json:"id"`
-}
\ No newline at end of file
+	ID       int       `json:"id"`
+	// Title is set when the session is renamed
+	Title     string    `json:"title,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// ParentID is the session a fork was made from, ForkedAt the ID of the
+	// last message it shares with it
+	ParentID int `json:"parent_id,omitempty"`
+	ForkedAt int `json:"forked_at,omitempty"`
+	// Summary stands in for the first SummaryUpTo messages when the session
+	// is sent to the model, the messages themselves are kept
+	Summary     string `json:"summary,omitempty"`
+	SummaryUpTo int    `json:"summary_upto,omitempty"`
+}
+
+// SessionInfo describes a session without loading its messages.
+type SessionInfo struct {
+	ID    int    `json:"id"`
+	Title string `json:"title,omitempty"`
+	// Preview is the start of the first user message
+	Preview string `json:"preview"`
+	// Messages counts the messages of the session, without the ones a fork
+	// shares with its parent
+	Messages  int       `json:"messages"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ParentID  int       `json:"parent_id,omitempty"`
+}
+
+// SearchResult is a message matching a search, Snippet holds the matching
+// part of its content.
+type SearchResult struct {
+	SessionID    int       `json:"session_id"`
+	SessionTitle string    `json:"session_title"`
+	MessageID    int       `json:"message_id"`
+	Role         string    `json:"role"`
+	Model        string    `json:"model,omitempty"`
+	Snippet      string    `json:"snippet"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// message has the fields of Message without its methods, so they can be
+// encoded the default way.
+type message Message
+
+// MarshalJSON writes the parts as the content when the message has any, so
+// multi-part messages keep the OpenAI format.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if len(m.Parts) == 0 {
+		return json.Marshal(message(m))
+	}
+
+	return json.Marshal(struct {
+		message
+		Content []ContentPart `json:"content"`
+	}{message: message(m), Content: m.Parts})
+}
+
+// UnmarshalJSON reads the content as either a string or a list of parts, so
+// sessions saved before messages had parts can still be read.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*m = Message(decoded.message)
+
+	content := bytes.TrimSpace(decoded.Content)
+	switch {
+	case len(content) == 0 || bytes.Equal(content, []byte("null")):
+		m.Content = ""
+	case content[0] == '[':
+		if err := json.Unmarshal(content, &m.Parts); err != nil {
+			return err
+		}
+		m.Content = m.Text()
+	default:
+		if err := json.Unmarshal(content, &m.Content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Text joins the text parts of a multi-part message.
+func (m Message) Text() string {
+	var texts []string
+	for _, part := range m.Parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// Images returns the number of image parts in the message.
+func (m Message) Images() int {
+	images := 0
+	for _, part := range m.Parts {
+		if part.Type == "image_url" {
+			images++
+		}
+	}
+
+	return images
+}
+
+// UsageRecord is the usage of a single request, SessionID is 0 for requests
+// outside of a chat.
+type UsageRecord struct {
+	SessionID        int       `json:"session_id,omitempty"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Images           int       `json:"images,omitempty"`
+	Cost             float64   `json:"cost"`
+	CreatedAt        time.Time `json:"created_at"`
+}